@@ -6,7 +6,10 @@ import (
 	"math"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"context"
 
@@ -25,6 +28,44 @@ var nameToActions map[string]Action
 // This is the default keybinding used by NewKeymap()
 var defaultKeyBinding map[string]Action
 
+// This is the global map of the names of actions that take arguments,
+// such as peco.Execute("command"), to the functions that create them
+var nameToParameterizedActions map[string]parameterizedAction
+
+// parameterizedAction creates an action from the arguments that were
+// given to it in the config file
+type parameterizedAction func(args []interface{}) (Action, error)
+
+// withStringArg creates a parameterizedAction that takes exactly one
+// string argument
+func withStringArg(create func(string) (Action, error)) parameterizedAction {
+	return func(args []interface{}) (Action, error) {
+		if len(args) != 1 {
+			return nil, errors.Errorf("expected 1 argument, got %d", len(args))
+		}
+		v, ok := args[0].(string)
+		if !ok {
+			return nil, errors.New("argument must be a quoted string")
+		}
+		return create(v)
+	}
+}
+
+// withIntArg creates a parameterizedAction that takes exactly one
+// integer argument
+func withIntArg(create func(int) (Action, error)) parameterizedAction {
+	return func(args []interface{}) (Action, error) {
+		if len(args) != 1 {
+			return nil, errors.Errorf("expected 1 argument, got %d", len(args))
+		}
+		v, ok := args[0].(int)
+		if !ok {
+			return nil, errors.New("argument must be a number")
+		}
+		return create(v)
+	}
+}
+
 // Execute fulfills the Action interface for AfterFunc
 func (a ActionFunc) Execute(ctx context.Context, state *Peco, e termbox.Event) {
 	a(ctx, state, e)
@@ -62,6 +103,7 @@ func init() {
 	// Build the global maps
 	nameToActions = map[string]Action{}
 	defaultKeyBinding = map[string]Action{}
+	nameToParameterizedActions = map[string]parameterizedAction{}
 
 	ActionFunc(doInvertSelection).Register("InvertSelection")
 	ActionFunc(doBeginningOfLine).Register("BeginningOfLine", termbox.KeyCtrlA)
@@ -106,6 +148,11 @@ func init() {
 	ActionFunc(doScrollLeft).Register("ScrollLeft")
 	ActionFunc(doScrollRight).Register("ScrollRight")
 
+	ActionFunc(doScrollPreviewUp).Register("ScrollPreviewUp")
+	ActionFunc(doScrollPreviewDown).Register("ScrollPreviewDown")
+	ActionFunc(doScrollPreviewPageUp).Register("ScrollPreviewPageUp")
+	ActionFunc(doScrollPreviewPageDown).Register("ScrollPreviewPageDown")
+
 	ActionFunc(doScrollFirstItem).Register("ScrollFirstItem", termbox.KeyHome)
 	ActionFunc(doScrollLastItem).Register("ScrollLastItem", termbox.KeyEnd)
 
@@ -126,6 +173,7 @@ func init() {
 	ActionFunc(doCancelRangeMode).Register("CancelRangeMode")
 	ActionFunc(doToggleQuery).Register("ToggleQuery", termbox.KeyCtrlT)
 	ActionFunc(doRefreshScreen).Register("RefreshScreen", termbox.KeyCtrlL)
+	ActionFunc(doReload).Register("Reload")
 	ActionFunc(doToggleSingleKeyJump).Register("ToggleSingleKeyJump")
 
 	ActionFunc(doToggleViewArround).Register("ViewArround", termbox.KeyCtrlV)
@@ -133,6 +181,11 @@ func init() {
 	ActionFunc(doGoToNextSelection).Register("GoToNextSelection", termbox.KeyCtrlK)
 	ActionFunc(doGoToPreviousSelection).Register("	doGoToPreviousSelection", termbox.KeyCtrlJ)
 
+	nameToParameterizedActions["peco.Execute"] = withStringArg(newExecuteAction)
+	nameToParameterizedActions["peco.SetQuery"] = withStringArg(newSetQueryAction)
+	nameToParameterizedActions["peco.SetFilter"] = withStringArg(newSetFilterAction)
+	nameToParameterizedActions["peco.JumpToLine"] = withIntArg(newJumpToLineAction)
+
 	ActionFunc(doKonamiCommand).RegisterKeySequence(
 		"KonamiCommand",
 		keyseq.KeyList{
@@ -315,13 +368,7 @@ func doFinish(ctx context.Context, state *Peco, _ termbox.Event) {
 		return
 	}
 
-	sel := NewSelection()
-	state.Selection().Copy(sel)
-	if sel.Len() == 0 {
-		if l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber()); err == nil {
-			sel.Add(l)
-		}
-	}
+	sel := selectionOrCurrentLine(state)
 
 	var stdin bytes.Buffer
 	sel.Ascend(func(it btree.Item) bool {
@@ -337,6 +384,35 @@ func doFinish(ctx context.Context, state *Peco, _ termbox.Event) {
 	cmd.Stdin = &stdin
 	cmd.Stdout = state.Stdout
 	cmd.Stderr = state.Stderr
+	cmd.Env = pecoEnviron(state, sel)
+
+	state.screen.Suspend()
+
+	err = cmd.Run()
+	state.screen.Resume()
+	state.Hub().SendDraw(ctx, &DrawOptions{DisableCache: true})
+	if err != nil {
+		// bail out, or otherwise the user cannot know what happened
+		state.Exit(errors.Wrap(err, `failed to execute command`))
+	}
+}
+
+// selectionOrCurrentLine returns a copy of the selection, or if no
+// lines are selected, a selection that contains the current line
+func selectionOrCurrentLine(state *Peco) *Selection {
+	sel := NewSelection()
+	state.Selection().Copy(sel)
+	if sel.Len() == 0 {
+		if l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber()); err == nil {
+			sel.Add(l)
+		}
+	}
+	return sel
+}
+
+// pecoEnviron returns the environment for the commands that peco
+// executes on the lines in sel
+func pecoEnviron(state *Peco, sel *Selection) []string {
 	// Setup some environment variables. Start with a copy of the current
 	// environment...
 	env := os.Environ()
@@ -359,17 +435,140 @@ func doFinish(ctx context.Context, state *Peco, _ termbox.Event) {
 		`PECO_QUERY=`+state.Query().String(),
 		`PECO_MATCHED_LINE_COUNT=`+strconv.Itoa(sel.Len()),
 	)
-	cmd.Env = env
+	return env
+}
 
-	state.screen.Suspend()
+// expandLines replaces "{}" in the command with the output of the
+// lines, each quoted so that the shell treats it as a single word
+func expandLines(command string, lines []line.Line) string {
+	args := make([]string, len(lines))
+	for i, l := range lines {
+		args[i] = util.ShellQuote(l.Output())
+	}
+	return strings.Replace(command, "{}", strings.Join(args, " "), -1)
+}
 
-	err = cmd.Run()
-	state.screen.Resume()
-	state.Hub().SendDraw(ctx, &DrawOptions{DisableCache: true})
-	if err != nil {
-		// bail out, or otherwise the user cannot know what happened
-		state.Exit(errors.Wrap(err, `failed to execute command`))
+// newExecuteAction creates the action for peco.Execute("command"),
+// which runs the command on the selected lines (or the current line)
+// without leaving peco. The screen is suspended while the command
+// runs, so that it can interact with the terminal
+func newExecuteAction(command string) (Action, error) {
+	if command == "" {
+		return nil, errors.New("command must not be empty")
 	}
+
+	return ActionFunc(func(ctx context.Context, state *Peco, _ termbox.Event) {
+		if pdebug.Enabled {
+			g := pdebug.Marker("doExecute %s", command)
+			defer g.End()
+		}
+
+		sel := selectionOrCurrentLine(state)
+		if sel.Len() == 0 {
+			return
+		}
+
+		var lines []line.Line
+		sel.Ascend(func(it btree.Item) bool {
+			lines = append(lines, it.(line.Line))
+			return true
+		})
+
+		cmd := util.Shell(expandLines(command, lines))
+		cmd.Env = pecoEnviron(state, sel)
+
+		// The input and output of peco itself are usually redirected,
+		// so connect the command to the terminal if we can
+		if in, out, err := util.OpenTerminal(); err == nil {
+			defer in.Close()
+			if out != in {
+				defer out.Close()
+			}
+			cmd.Stdin = in
+			cmd.Stdout = out
+			cmd.Stderr = out
+		} else {
+			cmd.Stdout = state.Stdout
+			cmd.Stderr = state.Stderr
+		}
+
+		state.screen.Suspend()
+		err := cmd.Run()
+		state.screen.Resume()
+		state.Hub().SendDraw(ctx, &DrawOptions{DisableCache: true})
+		if err != nil {
+			state.Hub().SendStatusMsgAndClear(ctx, "Command failed: "+err.Error(), 5*time.Second)
+		}
+	}), nil
+}
+
+// newSetQueryAction creates the action for peco.SetQuery("query"),
+// which replaces the query and executes it
+func newSetQueryAction(query string) (Action, error) {
+	return ActionFunc(func(ctx context.Context, state *Peco, _ termbox.Event) {
+		if pdebug.Enabled {
+			g := pdebug.Marker("doSetQuery %s", query)
+			defer g.End()
+		}
+
+		state.Query().Set(query)
+		state.Caret().SetPos(utf8.RuneCountInString(query))
+
+		if state.ExecQuery(nil) {
+			return
+		}
+		state.Hub().SendDrawPrompt(ctx)
+	}), nil
+}
+
+// newSetFilterAction creates the action for peco.SetFilter("name"),
+// which switches to the filter with the given name
+func newSetFilterAction(name string) (Action, error) {
+	if name == "" {
+		return nil, errors.New("filter name must not be empty")
+	}
+
+	return ActionFunc(func(ctx context.Context, state *Peco, _ termbox.Event) {
+		if pdebug.Enabled {
+			g := pdebug.Marker("doSetFilter %s", name)
+			defer g.End()
+		}
+
+		if err := state.Filters().SetCurrentByName(name); err != nil {
+			state.Hub().SendStatusMsgAndClear(ctx, "No such filter: "+name, 5*time.Second)
+			return
+		}
+
+		if state.ExecQuery(nil) {
+			return
+		}
+		state.Hub().SendDrawPrompt(ctx)
+	}), nil
+}
+
+// newJumpToLineAction creates the action for peco.JumpToLine(n), which
+// moves the cursor to the n-th line (starting from 1) of the current
+// buffer. Negative values count from the last line
+func newJumpToLineAction(n int) (Action, error) {
+	if n == 0 {
+		return nil, errors.New("line number must not be 0")
+	}
+
+	return ActionFunc(func(ctx context.Context, state *Peco, _ termbox.Event) {
+		if pdebug.Enabled {
+			g := pdebug.Marker("doJumpToLine %d", n)
+			defer g.End()
+		}
+
+		lineno := n - 1
+		if n < 0 {
+			lineno = state.CurrentLineBuffer().Size() + n
+			if lineno < 0 {
+				lineno = 0
+			}
+		}
+		state.Hub().SendPaging(ctx, LineNumberRequest(lineno))
+	}), nil
 }
 
 func doCancel(ctx context.Context, state *Peco, e termbox.Event) {
@@ -425,6 +624,22 @@ func doScrollRight(ctx context.Context, state *Peco, e termbox.Event) {
 	state.Hub().SendPaging(ctx, ToScrollRight)
 }
 
+func doScrollPreviewUp(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ctx, ToPreviewLineUp)
+}
+
+func doScrollPreviewDown(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ctx, ToPreviewLineDown)
+}
+
+func doScrollPreviewPageUp(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ctx, ToPreviewPageUp)
+}
+
+func doScrollPreviewPageDown(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ctx, ToPreviewPageDown)
+}
+
 func doScrollFirstItem(ctx context.Context, state *Peco, e termbox.Event) {
 	state.Hub().SendPaging(ctx, ToScrollFirstItem)
 }
@@ -746,6 +961,19 @@ func doRefreshScreen(ctx context.Context, state *Peco, _ termbox.Event) {
 	state.Hub().SendDraw(ctx, &DrawOptions{DisableCache: true})
 }
 
+func doReload(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doReload")
+		defer g.End()
+	}
+
+	if err := state.ReloadSource(ctx, state.Query().String()); err != nil {
+		state.Hub().SendStatusMsgAndClear(ctx, err.Error(), 5*time.Second)
+		return
+	}
+	state.Hub().SendDraw(ctx, &DrawOptions{DisableCache: true})
+}
+
 func doToggleQuery(ctx context.Context, state *Peco, _ termbox.Event) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("doToggleQuery")
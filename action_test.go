@@ -1,6 +1,10 @@
 package peco
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 	"unicode/utf8"
@@ -9,6 +13,7 @@ import (
 
 	"github.com/nsf/termbox-go"
 	"github.com/peco/peco/filter"
+	"github.com/peco/peco/line"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -371,3 +376,229 @@ func TestBackToInitialFilter(t *testing.T) {
 		return
 	}
 }
+
+func TestExecute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-test-execute-")
+	if !assert.NoError(t, err, "ioutil.TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "output")
+
+	state := newPeco()
+	state.hub = nullHub{}
+	state.source = NewSource("-", strings.NewReader(""), false, state.idgen, 0, false)
+
+	lines := []line.Line{
+		line.NewRaw(0, "foo", false),
+		line.NewRaw(1, "bar baz", false),
+		line.NewRaw(2, "qux", false),
+	}
+	buf := NewMemoryBuffer()
+	buf.lines = lines
+	state.SetCurrentLineBuffer(buf)
+	state.Query().Set("hello")
+	state.Location().SetLineNumber(2)
+
+	km := NewKeymap(nil, nil)
+	a, err := km.resolveActionName(`peco.Execute("printf '%s\n' {} \"$PECO_QUERY\" > `+output+`")`, 0)
+	if !assert.NoError(t, err, "resolveActionName should succeed") {
+		return
+	}
+
+	t.Run("Current line", func(t *testing.T) {
+		a.Execute(context.Background(), state, termbox.Event{})
+
+		b, err := ioutil.ReadFile(output)
+		if !assert.NoError(t, err, "command should write to the output file") {
+			return
+		}
+		if !assert.Equal(t, "qux\nhello\n", string(b), "command runs on the current line") {
+			return
+		}
+	})
+
+	t.Run("Selection", func(t *testing.T) {
+		state.Selection().Add(lines[0])
+		state.Selection().Add(lines[1])
+		a.Execute(context.Background(), state, termbox.Event{})
+
+		b, err := ioutil.ReadFile(output)
+		if !assert.NoError(t, err, "command should write to the output file") {
+			return
+		}
+		if !assert.Equal(t, "foo\nbar baz\nhello\n", string(b), "command runs on the selected lines") {
+			return
+		}
+
+		// The state is left as it was
+		if !assert.Equal(t, "hello", state.Query().String(), "query is kept") {
+			return
+		}
+		if !assert.Equal(t, 2, state.Selection().Len(), "selection is kept") {
+			return
+		}
+		if !assert.Equal(t, 2, state.Location().LineNumber(), "location is kept") {
+			return
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, name := range []string{
+			`peco.Execute(ls)`,
+			`peco.Execute("")`,
+			`peco.Execute("ls"`,
+			`peco.NoSuchAction("ls")`,
+		} {
+			_, err := km.resolveActionName(name, 0)
+			if !assert.Error(t, err, "resolving %s should fail", name) {
+				return
+			}
+		}
+	})
+}
+
+func TestParseActionArgs(t *testing.T) {
+	testValues := []struct {
+		args     string
+		expected []interface{}
+	}{
+		{`()`, nil},
+		{`("foo")`, []interface{}{"foo"}},
+		{"(`a \"b\"`)", []interface{}{`a "b"`}},
+		{`( "a\tb" , 10, -2 )`, []interface{}{"a\tb", 10, -2}},
+	}
+	for _, v := range testValues {
+		args, err := parseActionArgs(v.args)
+		if !assert.NoError(t, err, "parseActionArgs(%s) should succeed", v.args) {
+			return
+		}
+		if !assert.Equal(t, v.expected, args, "parseActionArgs(%s) should match", v.args) {
+			return
+		}
+	}
+
+	for _, v := range []string{
+		``,
+		`(`,
+		`("foo"`,
+		`("foo" "bar")`,
+		`("foo",)`,
+		`(foo)`,
+		`(1.5)`,
+		`(-"foo")`,
+		`("foo") bar`,
+		`("foo)`,
+	} {
+		_, err := parseActionArgs(v)
+		if !assert.Error(t, err, "parseActionArgs(%s) should fail", v) {
+			return
+		}
+	}
+}
+
+type pagingHub struct {
+	nullHub
+	requests []interface{}
+}
+
+func (h *pagingHub) SendPaging(_ context.Context, v interface{}) {
+	h.requests = append(h.requests, v)
+}
+
+func TestParameterizedActions(t *testing.T) {
+	newState := func() (*Peco, *pagingHub) {
+		h := &pagingHub{}
+		state := newPeco()
+		state.hub = h
+		state.filters.Add(filter.NewIgnoreCase())
+		state.filters.Add(filter.NewRegexp())
+
+		buf := NewMemoryBuffer()
+		buf.lines = []line.Line{
+			line.NewRaw(0, "foo", false),
+			line.NewRaw(1, "bar", false),
+			line.NewRaw(2, "baz", false),
+		}
+		state.SetCurrentLineBuffer(buf)
+		return state, h
+	}
+
+	execute := func(state *Peco, name string) bool {
+		km := NewKeymap(nil, nil)
+		a, err := km.resolveActionName(name, 0)
+		if !assert.NoError(t, err, "resolveActionName(%s) should succeed", name) {
+			return false
+		}
+		a.Execute(context.Background(), state, termbox.Event{})
+		return true
+	}
+
+	t.Run("SetQuery", func(t *testing.T) {
+		state, _ := newState()
+		state.Query().Set("hello")
+		if !execute(state, `peco.SetQuery("ばz")`) {
+			return
+		}
+		if !assert.Equal(t, "ばz", state.Query().String(), "query should be replaced") {
+			return
+		}
+		if !assert.Equal(t, 2, state.Caret().Pos(), "caret should be at the end of the query") {
+			return
+		}
+	})
+
+	t.Run("SetFilter", func(t *testing.T) {
+		state, _ := newState()
+		if !execute(state, `peco.SetFilter("Regexp")`) {
+			return
+		}
+		if !assert.Equal(t, "Regexp", state.Filters().Current().String(), "filter should be switched") {
+			return
+		}
+
+		// Unknown filters leave the current filter alone
+		if !execute(state, `peco.SetFilter("NoSuchFilter")`) {
+			return
+		}
+		if !assert.Equal(t, "Regexp", state.Filters().Current().String(), "filter should be kept") {
+			return
+		}
+	})
+
+	t.Run("JumpToLine", func(t *testing.T) {
+		state, h := newState()
+		for _, name := range []string{`peco.JumpToLine(2)`, `peco.JumpToLine(-1)`, `peco.JumpToLine(-10)`} {
+			if !execute(state, name) {
+				return
+			}
+		}
+		expected := []interface{}{LineNumberRequest(1), LineNumberRequest(2), LineNumberRequest(0)}
+		if !assert.Equal(t, expected, h.requests, "paging requests should match") {
+			return
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		km := NewKeymap(nil, nil)
+		for _, name := range []string{
+			`peco.SetQuery()`,
+			`peco.SetQuery(10)`,
+			`peco.SetQuery("a", "b")`,
+			`peco.SetFilter("")`,
+			`peco.JumpToLine("10")`,
+			`peco.JumpToLine(0)`,
+		} {
+			_, err := km.resolveActionName(name, 0)
+			if !assert.Error(t, err, "resolving %s should fail", name) {
+				return
+			}
+		}
+
+		// Errors are reported when the config is loaded
+		km = NewKeymap(map[string]string{"C-x": `peco.JumpToLine(1`}, nil)
+		if !assert.Error(t, km.ApplyKeybinding(), "ApplyKeybinding should fail") {
+			return
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package peco
 
 import (
+	"sort"
 	"time"
 
 	"context"
@@ -75,6 +76,16 @@ func NewMemoryBuffer() *MemoryBuffer {
 	return mb
 }
 
+// SetRankFunc makes the buffer keep its lines ordered by rank,
+// instead of the order in which they were accepted. rank reports
+// whether a should be placed before b. Lines that rank equally are
+// kept in the order they were accepted
+func (mb *MemoryBuffer) SetRankFunc(rank func(a, b line.Line) bool) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+	mb.rank = rank
+}
+
 func (mb *MemoryBuffer) Size() int {
 	mb.mutex.RLock()
 	defer mb.mutex.RUnlock()
@@ -113,6 +124,17 @@ func (mb *MemoryBuffer) Accept(ctx context.Context, in chan interface{}, _ pipel
 		mb.mutex.Unlock()
 	}()
 
+	mb.mutex.RLock()
+	rank := mb.rank
+	mb.mutex.RUnlock()
+
+	// When the lines are ranked, incoming lines are kept aside and
+	// periodically merged into the buffer, so that we do not have to
+	// move the existing lines around for every line that comes in
+	var pending []line.Line
+	mergeTicker := time.NewTicker(50 * time.Millisecond)
+	defer mergeTicker.Stop()
+
 	start := time.Now()
 	for {
 		select {
@@ -121,16 +143,28 @@ func (mb *MemoryBuffer) Accept(ctx context.Context, in chan interface{}, _ pipel
 				pdebug.Printf("MemoryBuffer received context done")
 			}
 			return
+		case <-mergeTicker.C:
+			if len(pending) > 0 {
+				mb.mergeRanked(pending, rank)
+				pending = nil
+			}
 		case v := <-in:
 			switch v.(type) {
 			case error:
 				if pipeline.IsEndMark(v.(error)) {
+					if len(pending) > 0 {
+						mb.mergeRanked(pending, rank)
+					}
 					if pdebug.Enabled {
 						pdebug.Printf("MemoryBuffer received end mark (read %d lines, %s since starting accept loop)", len(mb.lines), time.Since(start).String())
 					}
 					return
 				}
 			case line.Line:
+				if rank != nil {
+					pending = append(pending, v.(line.Line))
+					continue
+				}
 				mb.mutex.Lock()
 				mb.lines = append(mb.lines, v.(line.Line))
 				mb.mutex.Unlock()
@@ -139,6 +173,33 @@ func (mb *MemoryBuffer) Accept(ctx context.Context, in chan interface{}, _ pipel
 	}
 }
 
+// mergeRanked sorts the pending lines, and merges them into the
+// lines that are already in the buffer.
+func (mb *MemoryBuffer) mergeRanked(pending []line.Line, rank func(a, b line.Line) bool) {
+	sort.SliceStable(pending, func(i, j int) bool {
+		return rank(pending[i], pending[j])
+	})
+
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	merged := make([]line.Line, 0, len(mb.lines)+len(pending))
+	i, j := 0, 0
+	for i < len(mb.lines) && j < len(pending) {
+		// Existing lines win ties, as they were accepted first
+		if rank(pending[j], mb.lines[i]) {
+			merged = append(merged, pending[j])
+			j++
+		} else {
+			merged = append(merged, mb.lines[i])
+			i++
+		}
+	}
+	merged = append(merged, mb.lines[i:]...)
+	merged = append(merged, pending[j:]...)
+	mb.lines = merged
+}
+
 func (mb *MemoryBuffer) LineAt(n int) (line.Line, error) {
 	mb.mutex.RLock()
 	defer mb.mutex.RUnlock()
@@ -0,0 +1,55 @@
+package peco
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBufferRank(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mb := NewMemoryBuffer()
+	mb.SetRankFunc(func(a, b line.Line) bool {
+		return len(a.DisplayString()) < len(b.DisplayString())
+	})
+
+	in := make(chan interface{})
+	go mb.Accept(ctx, in, nil)
+
+	// Send the lines in two batches, with enough time in between
+	// for the first batch to be merged into the buffer
+	for i, s := range []string{"ccc", "a", "bb"} {
+		in <- line.NewRaw(uint64(i), s, false)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i, s := range []string{"dddd", "x", "yy"} {
+		in <- line.NewRaw(uint64(i+3), s, false)
+	}
+	in <- pipeline.EndMark{}
+
+	select {
+	case <-mb.Done():
+	case <-ctx.Done():
+		t.Fatalf("unexpected timeout")
+	}
+
+	var actual []string
+	for i := 0; i < mb.Size(); i++ {
+		l, err := mb.LineAt(i)
+		if !assert.NoError(t, err, "LineAt should succeed") {
+			return
+		}
+		actual = append(actual, l.DisplayString())
+	}
+
+	// Lines that rank equally stay in the order they were accepted
+	if !assert.Equal(t, []string{"a", "x", "bb", "yy", "ccc", "dddd"}, actual, "lines are ranked across batches") {
+		return
+	}
+}
@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	"strconv"
+	"strings"
 
 	"github.com/nsf/termbox-go"
 	"github.com/peco/peco/filter"
@@ -115,6 +115,8 @@ func (ss *StyleSet) Init() {
 	ss.SavedSelection.bg = termbox.ColorCyan
 	ss.Selected.fg = termbox.ColorDefault | termbox.AttrUnderline
 	ss.Selected.bg = termbox.ColorMagenta
+	ss.Header.fg = termbox.ColorDefault | termbox.AttrBold
+	ss.Header.bg = termbox.ColorDefault
 }
 
 // UnmarshalJSON satisfies json.RawMessage.
@@ -136,7 +138,9 @@ func stringsToStyle(style *Style, raw []string) error {
 			style.fg = fg
 		} else {
 			if fg, err := strconv.ParseUint(s, 10, 8); err == nil {
-				style.fg = termbox.Attribute(fg+1)
+				style.fg = termbox.Attribute(fg + 1)
+			} else if fg, ok := parseRGBColor(s); ok {
+				style.fg = fg
 			}
 		}
 
@@ -146,7 +150,9 @@ func stringsToStyle(style *Style, raw []string) error {
 		} else {
 			if strings.HasPrefix(s, "on_") {
 				if bg, err := strconv.ParseUint(s[3:], 10, 8); err == nil {
-					style.bg = termbox.Attribute(bg+1)
+					style.bg = termbox.Attribute(bg + 1)
+				} else if bg, ok := parseRGBColor(s[3:]); ok {
+					style.bg = bg
 				}
 			}
 		}
@@ -165,6 +171,28 @@ func stringsToStyle(style *Style, raw []string) error {
 	return nil
 }
 
+// rgbColorBase is the lowest bit of the 24-bit color that
+// termbox.RGBToAttribute stores in an attribute, above the palette
+// colors and the attributes such as bold
+const rgbColorBase = termbox.AttrReverse << 1
+
+// parseRGBColor parses a 24-bit color such as "#ff8800"
+func parseRGBColor(s string) (termbox.Attribute, bool) {
+	if len(s) != 7 || s[0] != '#' {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	return termbox.RGBToAttribute(uint8(v>>16), uint8(v>>8), uint8(v)), true
+}
+
+// isRGBColor returns true if the attribute holds a 24-bit color
+func isRGBColor(attr termbox.Attribute) bool {
+	return attr >= rgbColorBase
+}
+
 // This is a variable because we want to change its behavior
 // when we run tests.
 type configLocateFunc func(string) (string, error)
@@ -64,6 +64,10 @@ func TestReadRC(t *testing.T) {
 				fg: termbox.ColorBlack | termbox.AttrBold,
 				bg: termbox.ColorCyan,
 			},
+			Header: Style{
+				fg: termbox.ColorDefault | termbox.AttrBold,
+				bg: termbox.ColorDefault,
+			},
 		},
 	}
 
@@ -103,6 +107,10 @@ func TestStringsToStyle(t *testing.T) {
 			strings: []string{"underline", "on_240", "214"},
 			style:   &Style{fg: (214+1) | termbox.AttrUnderline, bg: 240+1},
 		},
+		stringsToStyleTest{
+			strings: []string{"bold", "on_#202020", "#ff8800"},
+			style:   &Style{fg: termbox.RGBToAttribute(0xff, 0x88, 0x00) | termbox.AttrBold, bg: termbox.RGBToAttribute(0x20, 0x20, 0x20)},
+		},
 	}
 
 	t.Logf("Checking strings -> color mapping...")
@@ -1,6 +1,8 @@
 package peco
 
 import (
+	"container/list"
+	"runtime"
 	"sync"
 	"time"
 
@@ -14,55 +16,172 @@ import (
 	"github.com/peco/peco/pipeline"
 )
 
-func newFilterProcessor(f filter.Filter, q string) *filterProcessor {
+// newFilterProcessor creates a filterProcessor that filters lines
+// with f. If scope is non-nil, only the fields selected by scope are
+// given to the filter
+func newFilterProcessor(f filter.Filter, q string, scope *line.FieldScope) *filterProcessor {
 	return &filterProcessor{
 		filter: f,
 		query:  q,
+		scope:  scope,
 	}
 }
 
 func (fp *filterProcessor) Accept(ctx context.Context, in chan interface{}, out pipeline.ChanOutput) {
-	acceptAndFilter(ctx, fp.filter, in, out)
+	acceptAndFilter(ctx, fp, in, out)
 }
 
-// This flusher is run in a separate goroutine so that the filter can
-// run separately from accepting incoming messages
-func flusher(ctx context.Context, f filter.Filter, incoming chan []line.Line, done chan struct{}, out pipeline.ChanOutput) {
+// Err returns the first error that the filter failed with, if any
+func (fp *filterProcessor) Err() error {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	return fp.err
+}
+
+func (fp *filterProcessor) setErr(err error) {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	if fp.err == nil {
+		fp.err = err
+	}
+}
+
+// filterWorker applies the filter to the chunks of lines it receives,
+// until the jobs channel is closed. Many of these are run at the same
+// time, so that the filtering can use all of the available CPUs
+func filterWorker(ctx context.Context, fp *filterProcessor, jobs chan *filterJob) {
 	if pdebug.Enabled {
-		g := pdebug.Marker("flusher goroutine")
+		g := pdebug.Marker("filter worker goroutine")
+		defer g.End()
+	}
+
+	for job := range jobs {
+		job.run(ctx, fp)
+	}
+}
+
+// run applies the filter to the lines in the job, and collects the
+// results so that they can be sent out in order later
+func (job *filterJob) run(ctx context.Context, fp *filterProcessor) {
+	defer close(job.done)
+	defer buffer.ReleaseLineListBuf(job.lines)
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	// The filter is applied to the fields selected by --nth, or to the
+	// text that lines such as JSON lines are matched against
+	lines := job.lines
+	scoped := false
+	for i, l := range job.lines {
+		var sl line.Line
+		if fp.scope != nil {
+			sl = fp.scope.Apply(l)
+		} else if ml, ok := l.(line.MatchStringer); ok {
+			sl = line.ScopeText(l, ml.MatchString())
+		} else {
+			continue
+		}
+
+		if !scoped {
+			lines = make([]line.Line, len(job.lines))
+			copy(lines, job.lines)
+			scoped = true
+		}
+		lines[i] = sl
+	}
+
+	results := make(chan interface{})
+	applyDone := make(chan struct{})
+	go func() {
+		defer close(applyDone)
+		job.err = fp.filter.Apply(ctx, lines, results)
+	}()
+
+	for {
+		select {
+		case v := <-results:
+			if l, ok := v.(line.Line); ok && scoped {
+				v = line.Unscope(l)
+			}
+			job.results = append(job.results, v)
+		case <-applyDone:
+			return
+		}
+	}
+}
+
+// mergeFiltered sends out the results of each job in the order that
+// the jobs were queued, which is the same order that the lines were
+// read from the source. Errors from the filter are passed to onError
+// before the end mark is sent
+func mergeFiltered(ctx context.Context, queue chan *filterJob, done chan struct{}, out pipeline.ChanOutput, onError func(error)) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("filter merger goroutine")
 		defer g.End()
 	}
 
 	defer close(done)
 	defer out.SendEndMark("end of filter")
 
-	for {
+	for job := range queue {
 		select {
 		case <-ctx.Done():
 			return
-		case buf, ok := <-incoming:
-			if !ok {
-				return
-			}
-			pdebug.Printf("flusher: %#v", buf)
-			f.Apply(ctx, buf, out)
-			buffer.ReleaseLineListBuf(buf)
+		case <-job.done:
+		}
+
+		if job.err != nil && onError != nil {
+			onError(job.err)
+		}
+		for _, v := range job.results {
+			out.Send(v)
 		}
 	}
 }
 
-func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{}, out pipeline.ChanOutput) {
-	flush := make(chan []line.Line)
-	flushDone := make(chan struct{})
-	go flusher(ctx, f, flush, flushDone, out)
+func acceptAndFilter(ctx context.Context, fp *filterProcessor, in chan interface{}, out pipeline.ChanOutput) {
+	// Filters such as external commands must not be applied to many
+	// chunks at the same time
+	workers := 1
+	if cf, ok := fp.filter.(filter.Concurrent); ok && cf.Concurrent() {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	jobs := make(chan *filterJob)
+	// queue holds the jobs in the order that they were created. It is
+	// buffered so that the workers can run ahead of the merger
+	queue := make(chan *filterJob, workers*2)
+	mergeDone := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go filterWorker(ctx, fp, jobs)
+	}
+	go mergeFiltered(ctx, queue, mergeDone, out, fp.setErr)
+
+	flush := func(lines []line.Line) {
+		job := &filterJob{
+			lines: lines,
+			done:  make(chan struct{}),
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case queue <- job:
+		}
+		select {
+		case <-ctx.Done():
+		case jobs <- job:
+		}
+	}
 
 	buf := buffer.GetLineListBuf()
-	bufsiz := f.BufSize()
+	bufsiz := fp.filter.BufSize()
 	if bufsiz <= 0 {
 		bufsiz = cap(buf)
 	}
-	defer func() { <-flushDone }() // Wait till the flush goroutine is done
-	defer close(flush)             // Kill the flush goroutine
+	defer func() { <-mergeDone }() // Wait till the merge goroutine is done
+	defer close(queue)             // Kill the merge goroutine
+	defer close(jobs)              // Kill the worker goroutines
 
 	flushTicker := time.NewTicker(50 * time.Millisecond)
 	defer flushTicker.Stop()
@@ -78,7 +197,7 @@ func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{},
 			return
 		case <-flushTicker.C:
 			if len(buf) > 0 {
-				flush <- buf
+				flush(buf)
 				buf = buffer.GetLineListBuf()
 			}
 		case v := <-in:
@@ -89,7 +208,7 @@ func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{},
 						pdebug.Printf("filter received end mark (read %d lines, %s since starting accept loop)", lines+len(buf), time.Since(start).String())
 					}
 					if len(buf) > 0 {
-						flush <- buf
+						flush(buf)
 						buf = nil
 					}
 				}
@@ -105,7 +224,7 @@ func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{},
 				// difference if we have a lot of lines to process.
 				buf = append(buf, v.(line.Line))
 				if len(buf) >= bufsiz {
-					flush <- buf
+					flush(buf)
 					buf = buffer.GetLineListBuf()
 				}
 			}
@@ -113,12 +232,141 @@ func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{},
 	}
 }
 
+// filterCacheSize is the number of query results that are kept
+// around by the Filter
+const filterCacheSize = 16
+
 func NewFilter(state *Peco) *Filter {
 	return &Filter{
+		cache: newFilterCache(filterCacheSize),
 		state: state,
 	}
 }
 
+func newFilterCache(capacity int) *filterCache {
+	return &filterCache{
+		capacity: capacity,
+		entries:  list.New(),
+	}
+}
+
+// Get returns the cached result of running the filter f with query
+// over src, or nil if there is none
+func (fc *filterCache) Get(src pipeline.Source, f filter.Filter, query string) *MemoryBuffer {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	for e := fc.entries.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*filterCacheEntry)
+		if entry.source == src && entry.filter == f && entry.query == query {
+			fc.entries.MoveToFront(e)
+			return entry.buf
+		}
+	}
+	return nil
+}
+
+// Narrowest returns the smallest cached result that may be used as
+// the source for running the filter f with query, or nil if there
+// is none. See filter.Narrower
+func (fc *filterCache) Narrowest(src pipeline.Source, f filter.Filter, query string) *MemoryBuffer {
+	n, ok := f.(filter.Narrower)
+	if !ok {
+		return nil
+	}
+
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	var found *filterCacheEntry
+	for e := fc.entries.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*filterCacheEntry)
+		if entry.source != src || entry.filter != f || !n.CanNarrow(entry.query, query) {
+			continue
+		}
+		if found == nil || entry.buf.Size() < found.buf.Size() {
+			found = entry
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	return found.buf
+}
+
+// Set stores the complete result of running the filter f with query
+// over src, evicting the least recently used result if necessary
+func (fc *filterCache) Set(src pipeline.Source, f filter.Filter, query string, buf *MemoryBuffer) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	for e := fc.entries.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*filterCacheEntry)
+		if entry.source == src && entry.filter == f && entry.query == query {
+			fc.entries.Remove(e)
+			break
+		}
+	}
+
+	fc.entries.PushFront(&filterCacheEntry{
+		source: src,
+		filter: f,
+		query:  query,
+		buf:    buf,
+	})
+	for fc.entries.Len() > fc.capacity {
+		fc.entries.Remove(fc.entries.Back())
+	}
+}
+
+func (bs bufferSource) Start(ctx context.Context, out pipeline.ChanOutput) {
+	defer out.SendEndMark("end of input")
+
+	size := bs.buf.Size()
+	for i := 0; i < size; i++ {
+		l, err := bs.buf.LineAt(i)
+		if err != nil {
+			return
+		}
+
+		// The lines in the result of a previous query carry the
+		// indices for that query. Send the original lines, so that
+		// the filter can build new ones
+		switch v := l.(type) {
+		case *line.Scored:
+			l = v.Line
+		case *line.Matched:
+			l = v.Line
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			out.Send(l)
+		}
+	}
+}
+
+// Reset is a no-op: the buffer does not change once its query is done
+func (bs bufferSource) Reset() {}
+
+// isSetupDone returns true if the source has been read to the end.
+// Sources that are not read in the background are always done
+func isSetupDone(src pipeline.Source) bool {
+	s, ok := src.(interface{ SetupDone() <-chan struct{} })
+	if !ok {
+		return true
+	}
+
+	select {
+	case <-s.SetupDone():
+		return true
+	default:
+		return false
+	}
+}
+
 // Work is the actual work horse that that does the matching
 // in a goroutine of its own. It wraps Matcher.Match().
 func (f *Filter) Work(ctx context.Context, q hub.Payload) {
@@ -140,19 +388,50 @@ func (f *Filter) Work(ctx context.Context, q hub.Payload) {
 		if !state.config.StickySelection {
 			state.Selection().Reset()
 		}
+		state.emitSourceMatchEvent(ctx)
 		return
 	}
 
+	src := state.Source()
+	selectedFilter := state.Filters().Current()
+	if buf := f.cache.Get(src, selectedFilter, query); buf != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("Using cached result for '%s'", query)
+		}
+		state.SetCurrentLineBuffer(buf)
+		state.Hub().SendStatusMsg(ctx, "")
+		state.Hub().SendDraw(ctx, &DrawOptions{RunningQuery: true})
+		if !state.config.StickySelection {
+			state.Selection().Reset()
+		}
+		state.emitMatchEvent(ctx, buf.Size())
+		return
+	}
+
+	// Results can only be cached (and reused) if they are complete,
+	// which requires that the source has been read to the end
+	cacheable := isSetupDone(src)
+
 	// Create a new pipeline
 	p := pipeline.New()
-	p.SetSource(state.Source())
+	if prev := f.cache.Narrowest(src, selectedFilter, query); cacheable && prev != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("Narrowing down a previous result (%d lines) for '%s'", prev.Size(), query)
+		}
+		p.SetSource(bufferSource{buf: prev})
+	} else {
+		p.SetSource(src)
+	}
 
 	// Wraps the actual filter
-	selectedFilter := state.Filters().Current()
 	ctx = selectedFilter.NewContext(ctx, query)
-	p.Add(newFilterProcessor(selectedFilter, query))
+	fp := newFilterProcessor(selectedFilter, query, state.FieldScope())
+	p.Add(fp)
 
 	buf := NewMemoryBuffer()
+	if r, ok := selectedFilter.(filter.Ranker); ok {
+		buf.SetRankFunc(r.RankFunc())
+	}
 	p.SetDestination(buf)
 	state.SetCurrentLineBuffer(buf)
 
@@ -170,7 +449,15 @@ func (f *Filter) Work(ctx context.Context, q hub.Payload) {
 		}
 		t := time.NewTicker(5 * time.Millisecond)
 		defer t.Stop()
-		defer state.Hub().SendStatusMsg(ctx, "")
+		defer func() {
+			// Make it clear that the filter failed, as opposed to the
+			// query simply not matching anything
+			if err := fp.Err(); err != nil {
+				state.Hub().SendStatusMsg(ctx, "Filter failed: "+err.Error())
+				return
+			}
+			state.Hub().SendStatusMsg(ctx, "")
+		}()
 		defer state.Hub().SendDraw(ctx, &DrawOptions{RunningQuery: true})
 		for {
 			select {
@@ -184,9 +471,17 @@ func (f *Filter) Work(ctx context.Context, q hub.Payload) {
 
 	<-p.Done()
 
+	if cacheable && ctx.Err() == nil && fp.Err() == nil {
+		f.cache.Set(src, selectedFilter, query, buf)
+	}
+
 	if !state.config.StickySelection {
 		state.Selection().Reset()
 	}
+
+	if ctx.Err() == nil && fp.Err() == nil {
+		state.emitMatchEvent(ctx, buf.Size())
+	}
 }
 
 // Loop keeps watching for incoming queries, and upon receiving
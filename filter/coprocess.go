@@ -0,0 +1,197 @@
+package filter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os/exec"
+
+	pdebug "github.com/lestrrat-go/pdebug"
+	"github.com/pkg/errors"
+)
+
+var errCoprocessExited = errors.New("coprocess exited")
+
+// startCoprocess starts the command, which keeps running until
+// Close is called, or until it exits on its own
+func startCoprocess(name string, args []string) (*coprocess, error) {
+	cmd := exec.Command(name, args...)
+	if pdebug.Enabled {
+		pdebug.Printf("Starting coprocess %s %v", cmd.Path, cmd.Args)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to get stdin pipe`)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to get stdout pipe`)
+	}
+
+	stderr := &stderrTail{}
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, `failed to start command`)
+	}
+
+	cp := &coprocess{
+		cmd:     cmd,
+		done:    make(chan struct{}),
+		pending: make(map[uint64]chan *coprocessResponse),
+		stderr:  stderr,
+		stdin:   stdin,
+	}
+	go cp.readLoop(bufio.NewReader(stdout))
+	return cp, nil
+}
+
+// readLoop reads the responses from the coprocess, and hands them
+// to the requests waiting for them. Responses to requests that are
+// no longer waiting (because they were canceled) are dropped
+func (cp *coprocess) readLoop(rdr *bufio.Reader) {
+	defer func() {
+		werr := cp.cmd.Wait()
+
+		cp.mutex.Lock()
+		if cp.err == nil {
+			if werr == nil {
+				werr = errCoprocessExited
+			}
+			cp.err = commandError(errors.Wrap(werr, `coprocess exited`), cp.stderr)
+		}
+		cp.mutex.Unlock()
+		close(cp.done)
+	}()
+
+	for {
+		b, err := rdr.ReadBytes('\n')
+		if len(b) > 0 {
+			var res coprocessResponse
+			if err := json.Unmarshal(b, &res); err != nil {
+				cp.mutex.Lock()
+				cp.err = errors.Wrap(err, `failed to parse response from coprocess`)
+				cp.mutex.Unlock()
+				cp.cmd.Process.Kill()
+				return
+			}
+
+			cp.mutex.Lock()
+			ch, ok := cp.pending[res.Seq]
+			delete(cp.pending, res.Seq)
+			cp.mutex.Unlock()
+
+			if ok {
+				ch <- &res
+			} else if pdebug.Enabled {
+				pdebug.Printf("Dropping response for request %d", res.Seq)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Alive returns false if the coprocess has exited
+func (cp *coprocess) Alive() bool {
+	select {
+	case <-cp.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Err returns the reason why the coprocess exited
+func (cp *coprocess) Err() error {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	return cp.err
+}
+
+// send writes the request to the coprocess. If ctx is canceled before
+// the coprocess has read all of it, send returns without waiting any
+// longer. The rest is still written in the background, unless the
+// coprocess is closed in the meantime
+func (cp *coprocess) send(ctx context.Context, req *coprocessRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, `failed to encode request`)
+	}
+	b = append(b, '\n')
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- cp.write(b) }()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// write writes b to the coprocess, after the other requests that are
+// being written
+func (cp *coprocess) write(b []byte) error {
+	cp.writeMutex.Lock()
+	defer cp.writeMutex.Unlock()
+	if _, err := cp.stdin.Write(b); err != nil {
+		return errors.Wrap(err, `failed to write request to coprocess`)
+	}
+	return nil
+}
+
+// Filter sends the lines to the coprocess, and waits for the reply
+// that lists the matching lines. If ctx is canceled while waiting, the coprocess
+// is notified, and its response is discarded when it arrives
+func (cp *coprocess) Filter(ctx context.Context, query string, lines []coprocessLine) (*coprocessResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *coprocessResponse, 1)
+
+	cp.mutex.Lock()
+	cp.seq++
+	seq := cp.seq
+	cp.pending[seq] = ch
+	cp.mutex.Unlock()
+
+	if err := cp.send(ctx, &coprocessRequest{Seq: seq, Query: query, Lines: lines}); err != nil {
+		cp.mutex.Lock()
+		delete(cp.pending, seq)
+		cp.mutex.Unlock()
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		cp.mutex.Lock()
+		delete(cp.pending, seq)
+		cp.mutex.Unlock()
+		// Let the coprocess know that it may skip this request.
+		// This is only a hint, so errors are ignored, and we do not
+		// wait for a coprocess that is not reading its input
+		go cp.send(context.Background(), &coprocessRequest{Seq: seq, Cancel: true})
+		return nil, ctx.Err()
+	case <-cp.done:
+		return nil, cp.Err()
+	case res := <-ch:
+		return res, nil
+	}
+}
+
+// Close closes the input to the coprocess, and kills it if it is
+// still running
+func (cp *coprocess) Close() error {
+	cp.stdin.Close()
+	if cp.Alive() {
+		cp.cmd.Process.Kill()
+	}
+	<-cp.done
+	return nil
+}
@@ -0,0 +1,272 @@
+package filter
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+	"github.com/pkg/errors"
+)
+
+// NewExtended creates a filter that understands an extended search
+// syntax, similar to that of fzf. Whitespace separated terms are
+// AND'ed together, and each term may be one of the following:
+//
+//	foo    fuzzy match
+//	'foo   exact (substring) match
+//	^foo   prefix match
+//	foo$   suffix match
+//	!foo   lines that do NOT contain foo (also !^foo and !foo$)
+//
+// Terms separated by a lone "|" form an OR group, so "a | b c"
+// matches lines that contain (a OR b) AND c. Each term uses smart
+// case: it is matched case-insensitively unless it contains an
+// upper case character.
+func NewExtended() *Extended {
+	return &Extended{}
+}
+
+func (ef *Extended) BufSize() int {
+	return 0
+}
+
+// Concurrent returns true, as matching does not change the filter
+func (ef *Extended) Concurrent() bool {
+	return true
+}
+
+func (ef *Extended) NewContext(ctx context.Context, query string) context.Context {
+	return newContext(ctx, query)
+}
+
+func (ef *Extended) String() string {
+	return "Extended"
+}
+
+func (ef *Extended) compile(query string) ([]extendedGroup, error) {
+	ef.mutex.Lock()
+	defer ef.mutex.Unlock()
+
+	if ef.compiled != nil && ef.query == query {
+		return ef.compiled, nil
+	}
+
+	groups, err := parseExtendedQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	ef.query = query
+	ef.compiled = groups
+	return groups, nil
+}
+
+func (ef *Extended) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	query := ctx.Value(queryKey).(string)
+	groups, err := ef.compile(query)
+	if err != nil {
+		return errors.Wrap(err, "failed to compile extended query")
+	}
+
+LINE:
+	for _, l := range lines {
+		v := l.DisplayString()
+		matches := [][]int{}
+		for _, g := range groups {
+			found := false
+			for _, t := range g {
+				ok, indices := t.match(v)
+				if !ok {
+					continue
+				}
+				found = true
+				matches = append(matches, indices...)
+			}
+			if !found {
+				continue LINE
+			}
+		}
+
+		// A query made only of negations matches, but has
+		// nothing to highlight
+		if len(matches) == 0 {
+			out.Send(l)
+			continue
+		}
+		out.Send(line.NewMatched(l, dedupeMatches(matches)))
+	}
+	return nil
+}
+
+// extendedGroup is a list of terms, any of which may match
+type extendedGroup []*extendedTerm
+
+type extendedTerm struct {
+	rx     *regexp.Regexp
+	fuzzy  bool
+	negate bool
+}
+
+// match returns true if the term is satisfied by s, along with the
+// indices that should be highlighted
+func (t *extendedTerm) match(s string) (bool, [][]int) {
+	if t.negate {
+		return !t.rx.MatchString(s), nil
+	}
+
+	if t.fuzzy {
+		m := t.rx.FindStringSubmatchIndex(s)
+		if m == nil {
+			return false, nil
+		}
+		// The first pair is the entire match, the rest are the
+		// individual characters of the query
+		indices := make([][]int, 0, len(m)/2-1)
+		for i := 2; i < len(m); i += 2 {
+			indices = append(indices, []int{m[i], m[i+1]})
+		}
+		return true, indices
+	}
+
+	m := t.rx.FindAllStringIndex(s, -1)
+	if m == nil {
+		return false, nil
+	}
+	return true, m
+}
+
+// splitExtendedQuery splits the query by whitespace. A backslash
+// may be used to include a literal space in a term
+func splitExtendedQuery(query string) []string {
+	var tokens []string
+	var buf strings.Builder
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\\' && i+1 < len(query) && query[i+1] == ' ':
+			buf.WriteByte(' ')
+			i++
+		case c == ' ' || c == '\t':
+			if buf.Len() > 0 {
+				tokens = append(tokens, buf.String())
+				buf.Reset()
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	if buf.Len() > 0 {
+		tokens = append(tokens, buf.String())
+	}
+	return tokens
+}
+
+func parseExtendedQuery(query string) ([]extendedGroup, error) {
+	var groups []extendedGroup
+	var current extendedGroup
+	continueGroup := false
+
+	for _, token := range splitExtendedQuery(query) {
+		if token == "|" {
+			// OR operator: the next term joins the current group
+			continueGroup = len(current) > 0
+			continue
+		}
+
+		term, err := parseExtendedTerm(token)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse term '%s'", token)
+		}
+		if term == nil {
+			// Incomplete term, such as a lone "!" or "^" that the
+			// user is still typing. Just ignore it
+			continue
+		}
+
+		if !continueGroup && len(current) > 0 {
+			groups = append(groups, current)
+			current = nil
+		}
+		current = append(current, term)
+		continueGroup = false
+	}
+	if len(current) > 0 {
+		groups = append(groups, current)
+	}
+	return groups, nil
+}
+
+func parseExtendedTerm(token string) (*extendedTerm, error) {
+	var t extendedTerm
+
+	if strings.HasPrefix(token, "!") {
+		t.negate = true
+		token = token[1:]
+	}
+
+	var exact, prefix, suffix bool
+	switch {
+	case strings.HasPrefix(token, "'"):
+		exact = true
+		token = token[1:]
+	case strings.HasPrefix(token, "^"):
+		prefix = true
+		token = token[1:]
+	}
+
+	if strings.HasSuffix(token, "$") {
+		suffix = true
+		token = token[:len(token)-1]
+	}
+
+	if token == "" {
+		return nil, nil
+	}
+
+	var flags string
+	if !util.ContainsUpper(token) {
+		flags = "(?i)"
+	}
+
+	// Negated terms and anchored terms are always exact matches,
+	// just like they are in fzf
+	if !t.negate && !exact && !prefix && !suffix {
+		t.fuzzy = true
+		var buf strings.Builder
+		buf.WriteString(flags)
+		for len(token) > 0 {
+			r, n := utf8.DecodeRuneInString(token)
+			token = token[n:]
+			if buf.Len() > len(flags) {
+				buf.WriteString(".*?")
+			}
+			buf.WriteByte('(')
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+			buf.WriteByte(')')
+		}
+		rx, err := regexp.Compile(buf.String())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to compile fuzzy term")
+		}
+		t.rx = rx
+		return &t, nil
+	}
+
+	reTxt := regexp.QuoteMeta(token)
+	if prefix {
+		reTxt = "^" + reTxt
+	}
+	if suffix {
+		reTxt = reTxt + "$"
+	}
+
+	rx, err := regexp.Compile(flags + reTxt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile term")
+	}
+	t.rx = rx
+	return &t, nil
+}
@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"os/exec"
+	"time"
 
 	pdebug "github.com/lestrrat-go/pdebug"
 	"github.com/peco/peco/line"
@@ -15,10 +17,6 @@ import (
 // NewExternalCmd creates a new filter that uses an external
 // command to filter the input
 func NewExternalCmd(name string, cmd string, args []string, threshold int, idgen line.IDGenerator, enableSep bool) *ExternalCmd {
-	if len(args) == 0 {
-		args = []string{"$QUERY"}
-	}
-
 	if threshold <= 0 {
 		threshold = DefaultCustomFilterBufferThreshold
 	}
@@ -27,6 +25,7 @@ func NewExternalCmd(name string, cmd string, args []string, threshold int, idgen
 		args:            args,
 		cmd:             cmd,
 		enableSep:       enableSep,
+		format:          ExternalCmdFormatText,
 		idgen:           idgen,
 		name:            name,
 		outCh:           pipeline.ChanOutput(make(chan interface{})),
@@ -34,7 +33,136 @@ func NewExternalCmd(name string, cmd string, args []string, threshold int, idgen
 	}
 }
 
-func (ecf ExternalCmd) BufSize() int {
+// SetFormat sets the format of the input and output of the command.
+// With ExternalCmdFormatText (the default), the command receives the
+// lines as they are on its standard input, and prints the matching
+// lines to its standard output. Lines that come out of the command
+// are new lines, and are not highlighted.
+//
+// With ExternalCmdFormatJSON, each line is given to the command as a
+// JSON object along with its ID:
+//
+//	{"id":1,"line":"foo bar"}
+//
+// and the command prints a JSON object for each matching line, with
+// the ID of the line and the byte ranges in the line to highlight:
+//
+//	{"id":1,"indices":[[0,3]]}
+//
+// The original lines are then sent out, highlighted as any other
+// match. Objects with unknown IDs, and invalid ranges are ignored
+func (ecf *ExternalCmd) SetFormat(format string) error {
+	switch format {
+	case "":
+		format = ExternalCmdFormatText
+	case ExternalCmdFormatText, ExternalCmdFormatJSON:
+	default:
+		return errors.Errorf("unknown format '%s'", format)
+	}
+	ecf.format = format
+	return nil
+}
+
+// SetPersistent enables the persistent mode. In this mode, the
+// command is started once, and kept running. Instead of receiving
+// the query as an argument and the lines as plain text, the command
+// receives requests to filter a batch of lines, one JSON object per
+// line on its standard input:
+//
+//	{"seq":1,"query":"foo","lines":[{"id":1,"line":"foo bar"},{"id":2,"line":"baz"}]}
+//
+// and must reply on its standard output with the IDs of the matching
+// lines, also as a single line of JSON, using the same "seq":
+//
+//	{"seq":1,"ids":[1]}
+//
+// Instead of (or in addition to) "ids", the reply may contain
+// "matches", which lists the matching lines along with the byte
+// ranges to highlight, in the same way as ExternalCmdFormatJSON:
+//
+//	{"seq":1,"matches":[{"id":1,"indices":[[0,3]]}]}
+//
+// Requests may be sent before the previous ones are answered. When
+// the query changes before a request is answered, a request with
+// "cancel" set to true and the same "seq" is sent, and the reply to
+// the canceled request is discarded. The command is restarted if it
+// exits, and is killed when Close is called
+func (ecf *ExternalCmd) SetPersistent(b bool) {
+	ecf.persistent = b
+}
+
+// SetTimeout sets how long each invocation of the command (or each
+// request in persistent mode) may take. When the timeout expires,
+// the command is killed, and Apply fails. Zero means no timeout
+func (ecf *ExternalCmd) SetTimeout(d time.Duration) {
+	ecf.timeout = d
+}
+
+// Close stops the command if it is running in persistent mode
+func (ecf *ExternalCmd) Close() error {
+	ecf.coprocMutex.Lock()
+	defer ecf.coprocMutex.Unlock()
+
+	if ecf.coproc == nil {
+		return nil
+	}
+	err := ecf.coproc.Close()
+	ecf.coproc = nil
+	return err
+}
+
+func (ecf *ExternalCmd) coprocess() (*coprocess, error) {
+	ecf.coprocMutex.Lock()
+	defer ecf.coprocMutex.Unlock()
+
+	if ecf.coproc != nil && ecf.coproc.Alive() {
+		return ecf.coproc, nil
+	}
+
+	cp, err := startCoprocess(ecf.cmd, ecf.args)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to start coprocess`)
+	}
+	ecf.coproc = cp
+	return cp, nil
+}
+
+func (ecf *ExternalCmd) applyPersistent(ctx context.Context, buf []line.Line, out pipeline.ChanOutput) error {
+	cp, err := ecf.coprocess()
+	if err != nil {
+		return err
+	}
+
+	query := ctx.Value(queryKey).(string)
+	lines := make([]coprocessLine, len(buf))
+	for i, l := range buf {
+		lines[i] = coprocessLine{ID: l.ID(), Line: l.DisplayString()}
+	}
+
+	res, err := cp.Filter(ctx, query, lines)
+	if err != nil {
+		return errors.Wrap(err, `failed to filter lines`)
+	}
+
+	matched := make(map[uint64][][]int, len(res.IDs)+len(res.Matches))
+	for _, id := range res.IDs {
+		matched[id] = nil
+	}
+	for _, m := range res.Matches {
+		matched[m.ID] = append(matched[m.ID], m.Indices...)
+	}
+
+	// Send the original lines in the order we received them, no
+	// matter which order the coprocess replied with
+	for _, l := range buf {
+		if indices, ok := matched[l.ID()]; ok {
+			out.Send(newMatchedLine(l, indices))
+		}
+	}
+	return nil
+}
+
+func (ecf *ExternalCmd) BufSize() int {
 	return ecf.thresholdBufsiz
 }
 
@@ -42,25 +170,57 @@ func (ecf *ExternalCmd) NewContext(ctx context.Context, query string) context.Co
 	return newContext(ctx, query)
 }
 
-func (ecf ExternalCmd) String() string {
+func (ecf *ExternalCmd) String() string {
 	return ecf.name
 }
 
 func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline.ChanOutput) (err error) {
 	defer func() {
-		if err := recover(); err != nil {
-			if pdebug.Enabled {
-				pdebug.Printf("err: %s", err)
-			}
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic while running '%s': %v", ecf.name, r)
 		}
-	}() // ignore errors
+	}()
 	if pdebug.Enabled {
 		g := pdebug.Marker("ExternalCmd.Apply").BindError(&err)
 		defer g.End()
 	}
 
+	parent := ctx
+	if ecf.timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, ecf.timeout)
+		defer cancel()
+	}
+
+	if ecf.persistent {
+		err = ecf.applyPersistent(ctx, buf, out)
+	} else {
+		err = ecf.applyOnce(ctx, buf, out)
+	}
+
+	if parent.Err() != nil {
+		// The query was canceled. Nobody cares about the result
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		if ecf.persistent {
+			// The command may be stuck. Get a fresh one next time
+			ecf.Close()
+		}
+		return errors.Errorf("'%s' timed out after %s", ecf.name, ecf.timeout)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "'%s' failed", ecf.name)
+	}
+	return nil
+}
+
+func (ecf *ExternalCmd) applyOnce(ctx context.Context, buf []line.Line, out pipeline.ChanOutput) error {
 	query := ctx.Value(queryKey).(string)
 	args := append([]string(nil), ecf.args...)
+	if len(args) == 0 {
+		args = []string{"$QUERY"}
+	}
 	for i, v := range args {
 		if v == "$QUERY" {
 			args[i] = query
@@ -72,12 +232,48 @@ func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline
 		pdebug.Printf("Executing command %s %v", cmd.Path, cmd.Args)
 	}
 
+	// newLine creates the line to send out from a line of output. In
+	// the JSON format, it only collects the matches in matched, and
+	// the original lines are sent out once all of the output is read
+	var newLine func([]byte) line.Line
+	var matched map[uint64][][]int
 	inbuf := &bytes.Buffer{}
-	for _, l := range buf {
-		inbuf.WriteString(l.DisplayString() + "\n")
+	if ecf.format == ExternalCmdFormatJSON {
+		matched = make(map[uint64][][]int)
+		known := make(map[uint64]struct{}, len(buf))
+		enc := json.NewEncoder(inbuf)
+		for _, l := range buf {
+			known[l.ID()] = struct{}{}
+			if err := enc.Encode(coprocessLine{ID: l.ID(), Line: l.DisplayString()}); err != nil {
+				return errors.Wrap(err, `failed to encode line`)
+			}
+		}
+		newLine = func(b []byte) line.Line {
+			var m externalMatch
+			if err := json.Unmarshal(b, &m); err != nil {
+				return nil
+			}
+			if _, ok := known[m.ID]; ok {
+				matched[m.ID] = append(matched[m.ID], m.Indices...)
+			}
+			return nil
+		}
+	} else {
+		for _, l := range buf {
+			inbuf.WriteString(l.DisplayString() + "\n")
+		}
+		newLine = func(b []byte) line.Line {
+			// TODO: need to redo the spec for custom matchers
+			// This is the ONLY location where we need to actually
+			// RECREATE a Raw, and thus the only place where
+			// ctx.enableSep is required.
+			return line.NewRaw(ecf.idgen.Next(), string(b), ecf.enableSep)
+		}
 	}
 
+	stderr := &stderrTail{}
 	cmd.Stdin = inbuf
+	cmd.Stderr = stderr
 	r, err := cmd.StdoutPipe()
 	if err != nil {
 		return errors.Wrap(err, `failed to get stdout pipe`)
@@ -88,8 +284,6 @@ func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline
 		return errors.Wrap(err, `failed to start command`)
 	}
 
-	go cmd.Wait()
-
 	cmdCh := make(chan line.Line)
 	go func(ctx context.Context, cmdCh chan line.Line, rdr *bufio.Reader) {
 		defer func() { recover() }()
@@ -101,14 +295,18 @@ func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline
 			default:
 			}
 
-			b, _, err := rdr.ReadLine()
-			if len(b) > 0 {
-				// TODO: need to redo the spec for custom matchers
-				// This is the ONLY location where we need to actually
-				// RECREATE a Raw, and thus the only place where
-				// ctx.enableSep is required.
+			b, err := rdr.ReadBytes('\n')
+			b = bytes.TrimRight(b, "\r\n")
+			if len(b) == 0 {
+				if err != nil {
+					return
+				}
+				continue
+			}
+
+			if l := newLine(b); l != nil {
 				select {
-				case cmdCh <- line.NewRaw(ecf.idgen.Next(), string(b), ecf.enableSep):
+				case cmdCh <- l:
 				case <-ctx.Done():
 					return
 				}
@@ -119,22 +317,40 @@ func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline
 		}
 	}(ctx, cmdCh, bufio.NewReader(r))
 
-	defer func() {
-		if p := cmd.Process; p != nil {
-			p.Kill()
-		}
-	}()
-
 	for {
 		select {
 		case <-ctx.Done():
-			return nil
+			cmd.Process.Kill()
+			go cmd.Wait()
+			return ctx.Err()
 		case l, ok := <-cmdCh:
-			if l == nil || !ok {
-				return nil
+			if ok {
+				out.Send(l)
+				continue
+			}
+
+			if err := ctx.Err(); err != nil {
+				cmd.Process.Kill()
+				go cmd.Wait()
+				return err
+			}
+
+			// All of the output has been read. See how the command
+			// exited
+			if err := cmd.Wait(); err != nil && !noMatches(err, stderr) {
+				return commandError(err, stderr)
 			}
-			out.Send(l)
+
+			// Send the original lines in the order we received them,
+			// once each, no matter how the command printed them
+			if matched != nil {
+				for _, l := range buf {
+					if indices, ok := matched[l.ID()]; ok {
+						out.Send(newMatchedLine(l, indices))
+					}
+				}
+			}
+			return nil
 		}
 	}
-	return nil
 }
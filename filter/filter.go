@@ -1,6 +1,12 @@
 package filter
 
-import "context"
+import (
+	"context"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/peco/peco/line"
+)
 
 // newContext initializes the context so that it is suitable
 // to be passed to `Run()`
@@ -58,3 +64,64 @@ func mergeMatches(a []int, b []int) []int {
 	}
 	return ret
 }
+
+// validMatches returns the matches that can be used to highlight s,
+// dropping those that are out of range or do not fall on character
+// boundaries. The matches are also deduped
+func validMatches(s string, matches [][]int) [][]int {
+	valid := make([][]int, 0, len(matches))
+	for _, m := range matches {
+		if len(m) != 2 || m[0] < 0 || m[0] >= m[1] || m[1] > len(s) {
+			continue
+		}
+		if !utf8.RuneStart(s[m[0]]) || (m[1] < len(s) && !utf8.RuneStart(s[m[1]])) {
+			continue
+		}
+		valid = append(valid, []int{m[0], m[1]})
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	return dedupeMatches(valid)
+}
+
+// newMatchedLine creates a line.Matched that highlights the given
+// matches in l, or returns l as is if there is nothing to highlight
+func newMatchedLine(l line.Line, matches [][]int) line.Line {
+	matches = validMatches(l.DisplayString(), matches)
+	if len(matches) == 0 {
+		return l
+	}
+	return line.NewMatched(l, matches)
+}
+
+// dedupeMatches sorts the given matches, and "dedupes" them. For
+// example, if we matched the same region twice, we don't want
+// that to be drawn
+func dedupeMatches(matches [][]int) [][]int {
+	sort.Sort(byMatchStart(matches))
+
+	deduped := make([][]int, 0, len(matches))
+	for i, m := range matches {
+		// Always push the first one
+		if i == 0 {
+			deduped = append(deduped, m)
+			continue
+		}
+
+		prev := deduped[len(deduped)-1]
+		switch {
+		case matchContains(prev, m):
+			// If the previous match contains this one, then
+			// don't do anything
+			continue
+		case matchOverlaps(prev, m):
+			// If the previous match overlaps with this one,
+			// merge the results and make it a bigger one
+			deduped[len(deduped)-1] = mergeMatches(prev, m)
+		default:
+			deduped = append(deduped, m)
+		}
+	}
+	return deduped
+}
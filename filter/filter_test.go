@@ -1,8 +1,12 @@
 package filter
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -320,3 +324,667 @@ func testFuzzyMatch(octx context.Context, t *testing.T, filter Filter) {
 		})
 	}
 }
+
+func TestExtended(t *testing.T) {
+	octx, ocancel := context.WithCancel(context.Background())
+	defer ocancel()
+
+	testValues := []struct {
+		name   string
+		query  string
+		input  []string
+		expect []string
+	}{
+		{
+			name:   "Fuzzy terms",
+			query:  "fbr",
+			input:  []string{"foo/bar", "foobaz", "FooBar"},
+			expect: []string{"foo/bar", "FooBar"},
+		},
+		{
+			name:   "Exact terms",
+			query:  "'obar",
+			input:  []string{"foo/bar", "foobar", "FooBar"},
+			expect: []string{"foobar", "FooBar"},
+		},
+		{
+			name:   "Smart case",
+			query:  "'Bar",
+			input:  []string{"foobar", "FooBar"},
+			expect: []string{"FooBar"},
+		},
+		{
+			name:   "Prefix and suffix",
+			query:  "^foo .go$",
+			input:  []string{"foo.go", "foo.golang", "barfoo.go", "foo/bar.go"},
+			expect: []string{"foo.go", "foo/bar.go"},
+		},
+		{
+			name:   "Negation",
+			query:  "go !vendor !^test",
+			input:  []string{"main.go", "vendor/lib.go", "test/main.go", "lib/test.go"},
+			expect: []string{"main.go", "lib/test.go"},
+		},
+		{
+			name:   "OR groups",
+			query:  "'.go$ | '.rs$ !^cmd",
+			input:  []string{"main.go", "main.rs", "main.py", "cmd/main.go"},
+			expect: []string{"main.go", "main.rs"},
+		},
+		{
+			name:   "Incomplete terms are ignored",
+			query:  "main !",
+			input:  []string{"main.go", "lib.go"},
+			expect: []string{"main.go"},
+		},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			f := NewExtended()
+			ctx, cancel := context.WithTimeout(f.NewContext(octx, v.query), 10*time.Second)
+			defer cancel()
+
+			var lines []line.Line
+			for i, raw := range v.input {
+				lines = append(lines, line.NewRaw(uint64(i), raw, false))
+			}
+
+			var actual []string
+			lc := make(chan interface{})
+			ec := make(chan error)
+			go func() {
+				ec <- f.Apply(ctx, lines, lc)
+			}()
+
+		OUTER:
+			for {
+				select {
+				case l := <-lc:
+					actual = append(actual, l.(line.Line).DisplayString())
+				case err := <-ec:
+					if !assert.NoError(t, err, `filter.Apply should succeed`) {
+						return
+					}
+					break OUTER
+				case <-ctx.Done():
+					t.Fatalf("unexpected timeout")
+				}
+			}
+
+			if !assert.Equal(t, v.expect, actual, "result matches expected") {
+				return
+			}
+		})
+	}
+
+	t.Run("Highlight indices", func(t *testing.T) {
+		f := NewExtended()
+		ctx, cancel := context.WithTimeout(f.NewContext(octx, "fb 'bar !baz"), 10*time.Second)
+		defer cancel()
+
+		ch := make(chan interface{}, 1)
+		err := f.Apply(ctx, []line.Line{line.NewRaw(0, "foo/bar", false)}, pipeline.ChanOutput(ch))
+		if !assert.NoError(t, err, `filter.Apply should succeed`) {
+			return
+		}
+
+		l := <-ch
+		if !assert.Implements(t, (*indexer)(nil), l, "result is an indexer") {
+			return
+		}
+		if !assert.Equal(t, [][]int{{0, 1}, {4, 7}}, l.(indexer).Indices(), "result has expected indices") {
+			return
+		}
+	})
+}
+
+func TestScoredFuzzy(t *testing.T) {
+	octx, ocancel := context.WithCancel(context.Background())
+	defer ocancel()
+
+	t.Run("Results are sorted by score", func(t *testing.T) {
+		f := NewScoredFuzzy()
+		ctx, cancel := context.WithTimeout(f.NewContext(octx, "fb"), 10*time.Second)
+		defer cancel()
+
+		input := []string{"xfxxbx", "foobar", "bf", "foo_bar", "xfb", "fooBar", "foo/bar"}
+		var lines []line.Line
+		for i, raw := range input {
+			lines = append(lines, line.NewRaw(uint64(i), raw, false))
+		}
+
+		var actual []string
+		lc := make(chan interface{})
+		ec := make(chan error)
+		go func() {
+			ec <- f.Apply(ctx, lines, lc)
+		}()
+
+	OUTER:
+		for {
+			select {
+			case l := <-lc:
+				actual = append(actual, l.(line.Line).DisplayString())
+			case err := <-ec:
+				if !assert.NoError(t, err, `filter.Apply should succeed`) {
+					return
+				}
+				break OUTER
+			case <-ctx.Done():
+				t.Fatalf("unexpected timeout")
+			}
+		}
+
+		// "fooBar" and "foo_bar" have the same score, so the
+		// shorter line comes first
+		expect := []string{"foo/bar", "fooBar", "foo_bar", "foobar", "xfb", "xfxxbx"}
+		if !assert.Equal(t, expect, actual, "result is ordered in expected order") {
+			return
+		}
+	})
+
+	t.Run("Best alignment is highlighted", func(t *testing.T) {
+		f := NewScoredFuzzy()
+		ctx, cancel := context.WithTimeout(f.NewContext(octx, "asdf"), 10*time.Second)
+		defer cancel()
+
+		lc := make(chan interface{}, 1)
+		if !assert.NoError(t, f.Apply(ctx, []line.Line{line.NewRaw(0, "as_asdf", false)}, lc), `filter.Apply should succeed`) {
+			return
+		}
+
+		l := <-lc
+		if !assert.Implements(t, (*indexer)(nil), l, "result is an indexer") {
+			return
+		}
+		//      as_asdf
+		//         ^^^^
+		if !assert.Equal(t, [][]int{{3, 4}, {4, 5}, {5, 6}, {6, 7}}, l.(indexer).Indices(), "result has expected indices") {
+			return
+		}
+	})
+
+	t.Run("RankFunc", func(t *testing.T) {
+		if !assert.Nil(t, NewFuzzy(true).RankFunc(), "non-scoring Fuzzy does not rank") {
+			return
+		}
+		rank := NewScoredFuzzy().RankFunc()
+		if !assert.NotNil(t, rank, "scoring Fuzzy ranks") {
+			return
+		}
+		hi := line.NewScored(line.NewRaw(0, "foo", false), nil, 10)
+		lo := line.NewScored(line.NewRaw(1, "f", false), nil, 5)
+		if !assert.True(t, rank(hi, lo), "higher score ranks first") {
+			return
+		}
+		if !assert.False(t, rank(lo, hi), "lower score ranks last") {
+			return
+		}
+	})
+}
+
+func TestCanNarrow(t *testing.T) {
+	testValues := []struct {
+		name   string
+		filter Narrower
+		prev   string
+		next   string
+		expect bool
+	}{
+		{name: "IgnoreCase appended", filter: NewIgnoreCase(), prev: "foo", next: "foo bar", expect: true},
+		{name: "SmartCase appended", filter: NewSmartCase(), prev: "foo", next: "fooB", expect: true},
+		{name: "IgnoreCase deleted", filter: NewIgnoreCase(), prev: "foo", next: "fo", expect: false},
+		{name: "Regexp", filter: NewRegexp(), prev: "foo", next: "foo$", expect: false},
+		{name: "Fuzzy appended", filter: NewFuzzy(false), prev: "fb", next: "fbr", expect: true},
+		{name: "FuzzyLongestSort", filter: NewFuzzy(true), prev: "fb", next: "fbr", expect: false},
+		{name: "FuzzyScoreSort", filter: NewScoredFuzzy(), prev: "fb", next: "fbr", expect: true},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			if !assert.Equal(t, v.expect, v.filter.CanNarrow(v.prev, v.next), "CanNarrow returns expected value") {
+				return
+			}
+		})
+	}
+
+	var ef Filter = NewExtended()
+	_, ok := ef.(Narrower)
+	if !assert.False(t, ok, "Extended can not be narrowed down") {
+		return
+	}
+}
+
+// TestHelperCoprocess is not a real test. It is run as the command
+// of a persistent ExternalCmd by TestExternalCmdPersistent, and
+// matches the lines that contain the query
+func TestHelperCoprocess(t *testing.T) {
+	if os.Getenv("PECO_WANT_HELPER_COPROCESS") != "1" {
+		return
+	}
+	if os.Getenv("PECO_HELPER_STOP_READING") == "1" {
+		// Like a command that is stuck, and never reads its input
+		time.Sleep(time.Minute)
+		os.Exit(1)
+	}
+
+	rdr := bufio.NewReader(os.Stdin)
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		b, err := rdr.ReadBytes('\n')
+		if err != nil {
+			os.Exit(0)
+		}
+
+		var req coprocessRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			os.Exit(1)
+		}
+		if req.Cancel {
+			continue
+		}
+		if req.Query == "exit" {
+			os.Exit(0)
+		}
+
+		res := coprocessResponse{Seq: req.Seq, IDs: []uint64{}}
+		for _, l := range req.Lines {
+			i := strings.Index(l.Line, req.Query)
+			if i < 0 {
+				continue
+			}
+			if os.Getenv("PECO_HELPER_REPLY_MATCHES") == "1" {
+				res.Matches = append(res.Matches, externalMatch{ID: l.ID, Indices: [][]int{{i, i + len(req.Query)}}})
+			} else {
+				res.IDs = append(res.IDs, l.ID)
+			}
+		}
+		enc.Encode(res)
+	}
+}
+
+func TestExternalCmdPersistent(t *testing.T) {
+	os.Setenv("PECO_WANT_HELPER_COPROCESS", "1")
+	defer os.Unsetenv("PECO_WANT_HELPER_COPROCESS")
+
+	f := NewExternalCmd("Helper", os.Args[0], []string{"-test.run=TestHelperCoprocess"}, 0, nil, false)
+	f.SetPersistent(true)
+	defer f.Close()
+
+	apply := func(query string, input []string) ([]string, error) {
+		ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), query), 10*time.Second)
+		defer cancel()
+
+		var lines []line.Line
+		for i, raw := range input {
+			lines = append(lines, line.NewRaw(uint64(i), raw, false))
+		}
+
+		lc := make(chan interface{}, len(lines))
+		err := f.Apply(ctx, lines, lc)
+		close(lc)
+
+		var actual []string
+		for l := range lc {
+			actual = append(actual, l.(line.Line).DisplayString())
+		}
+		return actual, err
+	}
+
+	actual, err := apply("foo", []string{"foo", "bar", "foobar"})
+	if !assert.NoError(t, err, "Apply should succeed") {
+		return
+	}
+	if !assert.Equal(t, []string{"foo", "foobar"}, actual, "matching lines are returned") {
+		return
+	}
+
+	f.coprocMutex.Lock()
+	cp := f.coproc
+	f.coprocMutex.Unlock()
+
+	actual, err = apply("bar", []string{"foo", "bar", "foobar"})
+	if !assert.NoError(t, err, "Apply should succeed") {
+		return
+	}
+	if !assert.Equal(t, []string{"bar", "foobar"}, actual, "matching lines are returned") {
+		return
+	}
+
+	f.coprocMutex.Lock()
+	if !assert.True(t, cp == f.coproc, "command is kept running between queries") {
+		f.coprocMutex.Unlock()
+		return
+	}
+	f.coprocMutex.Unlock()
+
+	// Make the command exit, and check that it is restarted
+	if _, err := apply("exit", []string{"foo"}); !assert.Error(t, err, "Apply should fail when the command exits") {
+		return
+	}
+	actual, err = apply("foo", []string{"foo", "bar"})
+	if !assert.NoError(t, err, "Apply should succeed after the command is restarted") {
+		return
+	}
+	if !assert.Equal(t, []string{"foo"}, actual, "matching lines are returned") {
+		return
+	}
+
+	t.Run("Canceled requests", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(f.NewContext(context.Background(), "foo"))
+		cancel()
+
+		lc := make(chan interface{}, 1)
+		if !assert.NoError(t, f.Apply(ctx, []line.Line{line.NewRaw(0, "foo", false)}, lc), "canceled Apply is not an error") {
+			return
+		}
+		if !assert.Len(t, lc, 0, "canceled Apply sends nothing") {
+			return
+		}
+
+		actual, err := apply("bar", []string{"foo", "bar"})
+		if !assert.NoError(t, err, "Apply should succeed") {
+			return
+		}
+		if !assert.Equal(t, []string{"bar"}, actual, "matching lines are returned") {
+			return
+		}
+	})
+
+	t.Run("Command that stops reading", func(t *testing.T) {
+		os.Setenv("PECO_HELPER_STOP_READING", "1")
+		defer os.Unsetenv("PECO_HELPER_STOP_READING")
+
+		f := NewExternalCmd("Helper", os.Args[0], []string{"-test.run=TestHelperCoprocess"}, 0, nil, false)
+		f.SetPersistent(true)
+		f.SetTimeout(200 * time.Millisecond)
+		defer f.Close()
+
+		// More than the pipe to the command can hold
+		var lines []line.Line
+		for i := 0; i < 4096; i++ {
+			lines = append(lines, line.NewRaw(uint64(i), strings.Repeat("x", 100), false))
+		}
+
+		start := time.Now()
+		err := f.Apply(f.NewContext(context.Background(), "x"), lines, make(chan interface{}, len(lines)))
+		if !assert.Error(t, err, "Apply should fail") {
+			return
+		}
+		if !assert.Contains(t, err.Error(), "timed out", "error says that the command timed out") {
+			return
+		}
+		if !assert.True(t, time.Since(start) < 10*time.Second, "writing to the command should time out") {
+			return
+		}
+
+		f.coprocMutex.Lock()
+		defer f.coprocMutex.Unlock()
+		assert.Nil(t, f.coproc, "command is closed, to be restarted by the next Apply")
+	})
+
+	t.Run("Matches with indices", func(t *testing.T) {
+		os.Setenv("PECO_HELPER_REPLY_MATCHES", "1")
+		defer os.Unsetenv("PECO_HELPER_REPLY_MATCHES")
+
+		f := NewExternalCmd("Helper", os.Args[0], []string{"-test.run=TestHelperCoprocess"}, 0, nil, false)
+		f.SetPersistent(true)
+		defer f.Close()
+
+		ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), "bar"), 10*time.Second)
+		defer cancel()
+
+		raw := line.NewRaw(1, "foobar", false)
+		lc := make(chan interface{}, 2)
+		if !assert.NoError(t, f.Apply(ctx, []line.Line{line.NewRaw(0, "foo", false), raw}, lc), "Apply should succeed") {
+			return
+		}
+		if !assert.Len(t, lc, 1, "one line matches") {
+			return
+		}
+
+		l := <-lc
+		if !assert.Equal(t, raw.ID(), l.(line.Line).ID(), "original line is returned") {
+			return
+		}
+		if !assert.Implements(t, (*indexer)(nil), l, "result is an indexer") {
+			return
+		}
+		if !assert.Equal(t, [][]int{{3, 6}}, l.(indexer).Indices(), "result has expected indices") {
+			return
+		}
+	})
+}
+
+// TestHelperJSONFilter is not a real test. It is run as the command
+// of an ExternalCmd by TestExternalCmdJSON, and matches the lines
+// that contain the query given as the last argument. With
+// PECO_HELPER_REPLY_REVERSED, the matches are printed in reverse
+// order, and the first match is printed again with the first byte
+func TestHelperJSONFilter(t *testing.T) {
+	if os.Getenv("PECO_WANT_HELPER_JSON_FILTER") != "1" {
+		return
+	}
+
+	query := os.Args[len(os.Args)-1]
+	reversed := os.Getenv("PECO_HELPER_REPLY_REVERSED") == "1"
+	dec := json.NewDecoder(os.Stdin)
+	enc := json.NewEncoder(os.Stdout)
+	var matches []externalMatch
+	for {
+		var l coprocessLine
+		if err := dec.Decode(&l); err != nil {
+			break
+		}
+		if i := strings.Index(l.Line, query); i >= 0 {
+			m := externalMatch{ID: l.ID, Indices: [][]int{{i, i + len(query)}, {-1, 100}}}
+			if !reversed {
+				enc.Encode(m)
+				continue
+			}
+			matches = append([]externalMatch{m}, matches...)
+		}
+	}
+
+	for _, m := range matches {
+		enc.Encode(m)
+	}
+	if len(matches) > 0 {
+		enc.Encode(externalMatch{ID: matches[len(matches)-1].ID, Indices: [][]int{{0, 1}}})
+	}
+	os.Exit(0)
+}
+
+func TestExternalCmdJSON(t *testing.T) {
+	os.Setenv("PECO_WANT_HELPER_JSON_FILTER", "1")
+	defer os.Unsetenv("PECO_WANT_HELPER_JSON_FILTER")
+
+	f := NewExternalCmd("Helper", os.Args[0], []string{"-test.run=TestHelperJSONFilter", "--", "$QUERY"}, 0, nil, false)
+	if !assert.NoError(t, f.SetFormat(ExternalCmdFormatJSON), "SetFormat should succeed") {
+		return
+	}
+	if !assert.Error(t, f.SetFormat("xml"), "SetFormat should fail for unknown formats") {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), "bar"), 10*time.Second)
+	defer cancel()
+
+	lines := []line.Line{
+		line.NewRaw(0, "foo", false),
+		line.NewRaw(1, "foobar", false),
+		line.NewRaw(2, "barbaz", false),
+	}
+
+	var actual []line.Line
+	lc := make(chan interface{})
+	ec := make(chan error)
+	go func() {
+		ec <- f.Apply(ctx, lines, lc)
+	}()
+
+OUTER:
+	for {
+		select {
+		case l := <-lc:
+			actual = append(actual, l.(line.Line))
+		case err := <-ec:
+			if !assert.NoError(t, err, `filter.Apply should succeed`) {
+				return
+			}
+			break OUTER
+		case <-ctx.Done():
+			t.Fatalf("unexpected timeout")
+		}
+	}
+
+	if !assert.Len(t, actual, 2, "two lines match") {
+		return
+	}
+	for i, expect := range []struct {
+		id      uint64
+		indices [][]int
+	}{
+		{id: 1, indices: [][]int{{3, 6}}},
+		{id: 2, indices: [][]int{{0, 3}}},
+	} {
+		if !assert.Equal(t, expect.id, actual[i].ID(), "original line is returned") {
+			return
+		}
+		if !assert.Implements(t, (*indexer)(nil), actual[i], "result is an indexer") {
+			return
+		}
+		// Out of range indices are dropped
+		if !assert.Equal(t, expect.indices, actual[i].(indexer).Indices(), "result has expected indices") {
+			return
+		}
+	}
+
+	t.Run("Out of order", func(t *testing.T) {
+		os.Setenv("PECO_HELPER_REPLY_REVERSED", "1")
+		defer os.Unsetenv("PECO_HELPER_REPLY_REVERSED")
+
+		ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), "o"), 10*time.Second)
+		defer cancel()
+
+		lc := make(chan interface{}, len(lines))
+		if !assert.NoError(t, f.Apply(ctx, lines, lc), "filter.Apply should succeed") {
+			return
+		}
+		close(lc)
+
+		var actual []line.Line
+		for l := range lc {
+			actual = append(actual, l.(line.Line))
+		}
+		if !assert.Len(t, actual, 2, "each matching line is sent once") {
+			return
+		}
+		for i, expect := range []struct {
+			id      uint64
+			indices [][]int
+		}{
+			// The indices of the duplicate are merged
+			{id: 0, indices: [][]int{{0, 2}}},
+			{id: 1, indices: [][]int{{1, 2}}},
+		} {
+			if !assert.Equal(t, expect.id, actual[i].ID(), "lines are sent in the order they were given") {
+				return
+			}
+			if !assert.Equal(t, expect.indices, actual[i].(indexer).Indices(), "result has expected indices") {
+				return
+			}
+		}
+	})
+}
+
+// TestHelperFailingFilter is not a real test. It is run as the
+// command of an ExternalCmd by TestExternalCmdFailure, and fails in
+// the way specified by the last argument
+func TestHelperFailingFilter(t *testing.T) {
+	if os.Getenv("PECO_WANT_HELPER_FAILING_FILTER") != "1" {
+		return
+	}
+
+	switch os.Args[len(os.Args)-1] {
+	case "sleep":
+		time.Sleep(time.Minute)
+	case "nomatch":
+		// Like grep, when none of the lines match
+		os.Exit(1)
+	case "exit1":
+		fmt.Fprintf(os.Stderr, "bad pattern\n")
+		os.Exit(1)
+	default:
+		fmt.Fprintf(os.Stderr, "something\nwent wrong\n")
+		os.Exit(2)
+	}
+}
+
+func TestExternalCmdFailure(t *testing.T) {
+	os.Setenv("PECO_WANT_HELPER_FAILING_FILTER", "1")
+	defer os.Unsetenv("PECO_WANT_HELPER_FAILING_FILTER")
+
+	newFilter := func() *ExternalCmd {
+		return NewExternalCmd("Helper", os.Args[0], []string{"-test.run=TestHelperFailingFilter", "--", "$QUERY"}, 0, nil, false)
+	}
+	lines := []line.Line{line.NewRaw(0, "foo", false)}
+
+	t.Run("Non-zero exit status", func(t *testing.T) {
+		f := newFilter()
+		ctx := f.NewContext(context.Background(), "exit")
+
+		err := f.Apply(ctx, lines, make(chan interface{}, 1))
+		if !assert.Error(t, err, "Apply should fail") {
+			return
+		}
+		if !assert.Contains(t, err.Error(), "went wrong", "error contains the last line of stderr") {
+			return
+		}
+	})
+
+	t.Run("No matches", func(t *testing.T) {
+		f := newFilter()
+		ctx := f.NewContext(context.Background(), "nomatch")
+
+		out := make(chan interface{}, 1)
+		if !assert.NoError(t, f.Apply(ctx, lines, out), "exit status 1 without an error message means no matches") {
+			return
+		}
+		if !assert.Len(t, out, 0, "no lines should be sent") {
+			return
+		}
+	})
+
+	t.Run("Exit status 1 with an error message", func(t *testing.T) {
+		f := newFilter()
+		ctx := f.NewContext(context.Background(), "exit1")
+
+		err := f.Apply(ctx, lines, make(chan interface{}, 1))
+		if !assert.Error(t, err, "Apply should fail") {
+			return
+		}
+		if !assert.Contains(t, err.Error(), "bad pattern", "error contains the last line of stderr") {
+			return
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		f := newFilter()
+		f.SetTimeout(100 * time.Millisecond)
+		ctx := f.NewContext(context.Background(), "sleep")
+
+		start := time.Now()
+		err := f.Apply(ctx, lines, make(chan interface{}, 1))
+		if !assert.Error(t, err, "Apply should fail") {
+			return
+		}
+		if !assert.Contains(t, err.Error(), "timed out", "error says that the command timed out") {
+			return
+		}
+		if !assert.True(t, time.Since(start) < 10*time.Second, "command is killed") {
+			return
+		}
+	})
+}
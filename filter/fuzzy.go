@@ -29,10 +29,31 @@ func NewFuzzy(sortLongest bool) *Fuzzy {
 	}
 }
 
+// NewScoredFuzzy builds a Fuzzy filter that scores each match
+// and outputs the result sorted by the score. For each line, the
+// alignment of the query with the highest score is picked, and
+// that is what gets highlighted.
+//
+// Matched characters earn bonuses when they are found at the start
+// of the line, right after a '/', '_', '-', '.' or a white space,
+// on a camelCase hump, or right after the previously matched
+// character. Gaps between matched characters are penalized.
+// Lines with the same score are sorted by their length.
+func NewScoredFuzzy() *Fuzzy {
+	return &Fuzzy{
+		sortScore: true,
+	}
+}
+
 func (ff Fuzzy) BufSize() int {
 	return 0
 }
 
+// Concurrent returns true, as matching does not change the filter
+func (ff *Fuzzy) Concurrent() bool {
+	return true
+}
+
 func (ff *Fuzzy) NewContext(ctx context.Context, query string) context.Context {
 	return newContext(ctx, query)
 }
@@ -41,9 +62,30 @@ func (ff Fuzzy) String() string {
 	return "Fuzzy"
 }
 
+// CanNarrow returns true if next only appends to prev, as any line
+// that contains the characters of next in order also contains those
+// of prev. FuzzyLongestSort sorts each batch of lines on its own, so
+// reusing its previous result would change the order of the lines
+func (ff *Fuzzy) CanNarrow(prev, next string) bool {
+	return !ff.sortLongest && strings.HasPrefix(next, prev)
+}
+
+// RankFunc returns the function used to order the results across
+// batches of lines. Only the scoring Fuzzy filter ranks its results,
+// as the other variants sort each batch on its own
+func (ff *Fuzzy) RankFunc() func(a, b line.Line) bool {
+	if !ff.sortScore {
+		return nil
+	}
+	return rankByScore
+}
+
 func (ff *Fuzzy) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
 	originalQuery := ctx.Value(queryKey).(string)
 	hasUpper := util.ContainsUpper(originalQuery)
+	if ff.sortScore {
+		return ff.applyScored(originalQuery, hasUpper, lines, out)
+	}
 	matched := []fuzzyMatchedItem{}
 
 LINE:
@@ -146,6 +188,61 @@ LINE:
 	return nil
 }
 
+func (ff *Fuzzy) applyScored(query string, hasUpper bool, lines []line.Line, out pipeline.ChanOutput) error {
+	query = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, query)
+	if query == "" {
+		return fmt.Errorf("the query has no valid character")
+	}
+
+	matched := []line.Line{}
+	for _, l := range lines {
+		score, matches, ok := scoreFuzzy(l.DisplayString(), query, hasUpper)
+		if !ok {
+			continue
+		}
+		matched = append(matched, line.NewScored(l, matches, score))
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return rankByScore(matched[i], matched[j])
+	})
+
+	for _, l := range matched {
+		out.Send(l)
+	}
+	return nil
+}
+
+type scorer interface {
+	Score() int
+}
+
+// rankByScore orders lines by their scores, then by their length,
+// and then by the order in which they were read
+func rankByScore(a, b line.Line) bool {
+	var sa, sb int
+	if s, ok := a.(scorer); ok {
+		sa = s.Score()
+	}
+	if s, ok := b.(scorer); ok {
+		sb = s.Score()
+	}
+	if sa != sb {
+		// Higher score is better
+		return sa > sb
+	}
+	if la, lb := len(a.DisplayString()), len(b.DisplayString()); la != lb {
+		// Shorter line is better
+		return la < lb
+	}
+	return a.ID() < b.ID()
+}
+
 func popRune(s string) (string, rune, int) {
 	r, n := utf8.DecodeRuneInString(s)
 	return s[n:], r, n
@@ -3,6 +3,8 @@ package filter
 import (
 	"context"
 	"errors"
+	"io"
+	"os/exec"
 	"regexp"
 	"sync"
 	"time"
@@ -18,6 +20,12 @@ var defaultFlags = regexpFlagList{}
 var queryKey = &struct{}{}
 var incomingBufferKey = &struct{}{}
 
+// Formats of the input and output of CustomFilters
+const (
+	ExternalCmdFormatText = "text"
+	ExternalCmdFormatJSON = "json"
+)
+
 // DefaultCustomFilterBufferThreshold is the default value
 // for BufferThreshold setting on CustomFilters.
 const DefaultCustomFilterBufferThreshold = 100
@@ -49,6 +57,13 @@ type regexpQuery struct {
 
 type Fuzzy struct {
 	sortLongest bool
+	sortScore   bool
+}
+
+type Extended struct {
+	compiled []extendedGroup
+	mutex    sync.Mutex
+	query    string
 }
 
 type Regexp struct {
@@ -64,11 +79,66 @@ type Regexp struct {
 type ExternalCmd struct {
 	args            []string
 	cmd             string
+	coproc          *coprocess
+	coprocMutex     sync.Mutex
 	enableSep       bool
+	format          string
 	idgen           line.IDGenerator
 	outCh           pipeline.ChanOutput
 	name            string
+	persistent      bool
 	thresholdBufsiz int
+	timeout         time.Duration
+}
+
+// stderrTail is an io.Writer that keeps the last few bytes that an
+// external command wrote to its standard error, so that they can be
+// shown when the command fails
+type stderrTail struct {
+	buf   []byte
+	mutex sync.Mutex
+}
+
+// coprocess is a long-running external command that filters lines
+// for an ExternalCmd in persistent mode
+type coprocess struct {
+	cmd        *exec.Cmd
+	done       chan struct{}
+	err        error
+	mutex      sync.Mutex
+	pending    map[uint64]chan *coprocessResponse
+	seq        uint64
+	stderr     *stderrTail
+	stdin      io.WriteCloser
+	writeMutex sync.Mutex
+}
+
+// coprocessRequest is sent to the coprocess as a single line of JSON
+type coprocessRequest struct {
+	Seq    uint64          `json:"seq"`
+	Query  string          `json:"query,omitempty"`
+	Lines  []coprocessLine `json:"lines,omitempty"`
+	Cancel bool            `json:"cancel,omitempty"`
+}
+
+type coprocessLine struct {
+	ID   uint64 `json:"id"`
+	Line string `json:"line"`
+}
+
+// coprocessResponse is received from the coprocess as a single line
+// of JSON, in reply to the coprocessRequest with the same Seq
+type coprocessResponse struct {
+	Seq     uint64          `json:"seq"`
+	IDs     []uint64        `json:"ids"`
+	Matches []externalMatch `json:"matches,omitempty"`
+}
+
+// externalMatch is how external commands report a matching line,
+// along with the byte ranges in the line that should be highlighted
+type externalMatch struct {
+	ID      uint64  `json:"id"`
+	Indices [][]int `json:"indices"`
 }
 
 type Filter interface {
@@ -77,3 +147,28 @@ type Filter interface {
 	NewContext(context.Context, string) context.Context
 	String() string
 }
+
+// Narrower is implemented by filters that can reuse the result of a
+// previous query. CanNarrow returns true if every line that matches
+// next is guaranteed to also match prev, in which case the lines that
+// matched prev can be filtered instead of the entire source
+type Narrower interface {
+	CanNarrow(prev, next string) bool
+}
+
+// Ranker is implemented by filters that order their results. The
+// function returned by RankFunc reports whether a should be listed
+// before b, and is used to keep all of the results for a query in
+// order, not just those within a single call to Apply. RankFunc
+// returns nil if the filter does not order its results
+type Ranker interface {
+	RankFunc() func(a, b line.Line) bool
+}
+
+// Concurrent is implemented by filters whose Apply may be called from
+// many goroutines at the same time, each with a different chunk of
+// lines. Other filters, such as external commands, are given one chunk
+// at a time
+type Concurrent interface {
+	Concurrent() bool
+}
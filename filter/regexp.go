@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"regexp"
-	"sort"
 	"strings"
 	"time"
 
@@ -72,10 +71,15 @@ func NewRegexp() *Regexp {
 	}
 }
 
-func (rf Regexp) BufSize() int {
+func (rf *Regexp) BufSize() int {
 	return 0
 }
 
+// Concurrent returns true, as matching does not change the filter
+func (rf *Regexp) Concurrent() bool {
+	return true
+}
+
 func (rf *Regexp) OutCh() <-chan interface{} {
 	rf.mutex.Lock()
 	defer rf.mutex.Unlock()
@@ -130,43 +134,23 @@ func (rf *Regexp) Apply(ctx context.Context, lines []line.Line, out pipeline.Cha
 			continue
 		}
 
-		sort.Sort(byMatchStart(matches))
-
-		// We need to "dedupe" the results. For example, if we matched the
-		// same region twice, we don't want that to be drawn
-
-		deduped := make([][]int, 0, len(matches))
-
-		for i, m := range matches {
-			// Always push the first one
-			if i == 0 {
-				deduped = append(deduped, m)
-				continue
-			}
-
-			prev := deduped[len(deduped)-1]
-			switch {
-			case matchContains(prev, m):
-				// If the previous match contains this one, then
-				// don't do anything
-				continue
-			case matchOverlaps(prev, m):
-				// If the previous match overlaps with this one,
-				// merge the results and make it a bigger one
-				deduped[len(deduped)-1] = mergeMatches(prev, m)
-			default:
-				deduped = append(deduped, m)
-			}
-		}
-		out.Send(line.NewMatched(l, deduped))
+		out.Send(line.NewMatched(l, dedupeMatches(matches)))
 	}
 	return nil
 }
 
-func (rf Regexp) String() string {
+func (rf *Regexp) String() string {
 	return rf.name
 }
 
+// CanNarrow returns true if next only appends to prev. This is only
+// the case for the filters that quote the query: all of the terms are
+// AND'ed, and appending to a literal term can only make it stricter.
+// Regular expressions do not have this property
+func (rf *Regexp) CanNarrow(prev, next string) bool {
+	return rf.quotemeta && strings.HasPrefix(next, prev)
+}
+
 func NewIgnoreCase() *Regexp {
 	rf := NewRegexp()
 	rf.flags = ignoreCaseFlags
@@ -0,0 +1,180 @@
+package filter
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Constants used to score fuzzy matches. A matched character is
+// worth scoreMatch points, plus a bonus depending on where in the
+// line it was found. Gaps between matched characters are penalized.
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+
+	// bonusBoundary is given to characters that follow a word
+	// separator such as '_', '-', '.' or a white space
+	bonusBoundary = scoreMatch / 2
+	// bonusSlash is given to characters that follow a '/', which
+	// makes path components rank higher
+	bonusSlash = bonusBoundary + 1
+	// bonusStart is given to the first character in the line
+	bonusStart = bonusBoundary + 2
+	// bonusCamel is given to upper case characters that follow a
+	// lower case character, i.e. humps in camelCase words
+	bonusCamel = bonusBoundary - 1
+	// bonusConsecutive is given to characters that immediately
+	// follow the previous matched character
+	bonusConsecutive = -(scoreGapStart + scoreGapExtension)
+	// bonusFirstCharMultiplier is applied to the bonus of the first
+	// character in the query
+	bonusFirstCharMultiplier = 2
+)
+
+const scoreUnmatched = -(1 << 30)
+
+// positionBonus returns the bonus given to a character c, when it
+// is preceded by prev. If c is the first character in the line,
+// prev should be utf8.RuneError
+func positionBonus(prev, c rune) int {
+	switch {
+	case prev == utf8.RuneError:
+		return bonusStart
+	case prev == '/' || prev == '\\':
+		return bonusSlash
+	case prev == '_' || prev == '-' || prev == '.' || prev == ':' || unicode.IsSpace(prev):
+		return bonusBoundary
+	case unicode.IsLower(prev) && unicode.IsUpper(c):
+		return bonusCamel
+	case !unicode.IsLetter(prev) && !unicode.IsDigit(prev) && (unicode.IsLetter(c) || unicode.IsDigit(c)):
+		return bonusBoundary
+	}
+	return 0
+}
+
+// scoreFuzzy finds the best alignment of query within txt, and
+// returns its score along with the byte offsets of each matched
+// character. If query cannot be found in txt, ok is false.
+//
+// The alignment is computed using dynamic programming, in a manner
+// similar to the Smith-Waterman algorithm: for each character in the
+// query, we remember the best score we can achieve when that
+// character is matched at each position in txt.
+func scoreFuzzy(txt, query string, caseSensitive bool) (score int, matches [][]int, ok bool) {
+	q := []rune(query)
+	if len(q) == 0 {
+		return 0, nil, false
+	}
+
+	runes := make([]rune, 0, len(txt))
+	offsets := make([]int, 0, len(txt)+1)
+	for i, r := range txt {
+		runes = append(runes, r)
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(txt))
+
+	eq := func(a, b rune) bool {
+		if caseSensitive {
+			return a == b
+		}
+		return a == b || unicode.ToUpper(a) == unicode.ToUpper(b)
+	}
+
+	// Bail out early if the query is not a subsequence of txt
+	qi := 0
+	for _, r := range runes {
+		if eq(r, q[qi]) {
+			qi++
+			if qi == len(q) {
+				break
+			}
+		}
+	}
+	if qi < len(q) {
+		return 0, nil, false
+	}
+
+	n := len(runes)
+	bonus := make([]int, n)
+	prev := utf8.RuneError
+	for j, r := range runes {
+		bonus[j] = positionBonus(prev, r)
+		prev = r
+	}
+
+	// h[i][j] is the best score when q[i] is matched at runes[j].
+	// from[i][j] is the position where q[i-1] was matched in that case
+	h := make([][]int, len(q))
+	from := make([][]int, len(q))
+	for i := range q {
+		h[i] = make([]int, n)
+		from[i] = make([]int, n)
+		for j := range h[i] {
+			h[i][j] = scoreUnmatched
+		}
+	}
+
+	for j := 0; j < n; j++ {
+		if eq(runes[j], q[0]) {
+			h[0][j] = scoreMatch + bonus[j]*bonusFirstCharMultiplier
+		}
+	}
+
+	for i := 1; i < len(q); i++ {
+		// best score (and its position) of q[i-1] matched somewhere
+		// before j-1, with the gap penalty applied
+		gapScore, gapFrom := scoreUnmatched, -1
+		for j := i; j < n; j++ {
+			if j >= 2 {
+				gapScore += scoreGapExtension
+				if s := h[i-1][j-2]; s != scoreUnmatched && s+scoreGapStart > gapScore {
+					gapScore, gapFrom = s+scoreGapStart, j-2
+				}
+			}
+
+			if !eq(runes[j], q[i]) {
+				continue
+			}
+
+			if s := h[i-1][j-1]; s != scoreUnmatched {
+				b := bonus[j]
+				if b < bonusConsecutive {
+					b = bonusConsecutive
+				}
+				h[i][j] = s + scoreMatch + b
+				from[i][j] = j - 1
+			}
+
+			if gapFrom >= 0 && gapScore > scoreUnmatched/2 {
+				if s := gapScore + scoreMatch + bonus[j]; s > h[i][j] {
+					h[i][j] = s
+					from[i][j] = gapFrom
+				}
+			}
+		}
+	}
+
+	last := len(q) - 1
+	best := -1
+	for j := 0; j < n; j++ {
+		if h[last][j] == scoreUnmatched {
+			continue
+		}
+		if best == -1 || h[last][j] > h[last][best] {
+			best = j
+		}
+	}
+	if best == -1 {
+		return 0, nil, false
+	}
+
+	score = h[last][best]
+	matches = make([][]int, len(q))
+	for i, j := last, best; i >= 0; i-- {
+		matches[i] = []int{offsets[j], offsets[j+1]}
+		j = from[i][j]
+	}
+	return score, matches, true
+}
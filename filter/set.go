@@ -1,6 +1,8 @@
 package filter
 
 import (
+	"io"
+
 	pdebug "github.com/lestrrat-go/pdebug"
 )
 
@@ -58,3 +60,22 @@ func (fs *Set) Current() Filter {
 	defer fs.mutex.Unlock()
 	return fs.filters[fs.current]
 }
+
+// Close releases the resources held by the filters, such as the
+// commands that CustomFilters keep running in persistent mode
+func (fs *Set) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	var err error
+	for _, f := range fs.filters {
+		c, ok := f.(io.Closer)
+		if !ok {
+			continue
+		}
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package filter
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxStderrTail is the number of bytes kept by stderrTail
+const maxStderrTail = 4096
+
+func (st *stderrTail) Write(b []byte) (int, error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.buf = append(st.buf, b...)
+	if len(st.buf) > maxStderrTail {
+		st.buf = st.buf[len(st.buf)-maxStderrTail:]
+	}
+	return len(b), nil
+}
+
+// LastLine returns the last non-empty line written to the standard
+// error, which is usually the most relevant error message
+func (st *stderrTail) LastLine() string {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	lines := bytes.Split(bytes.TrimSpace(st.buf), []byte{'\n'})
+	return strings.TrimSpace(string(lines[len(lines)-1]))
+}
+
+// noMatches returns true if err means that the command found nothing,
+// rather than that it failed. grep and similar commands exit with
+// status 1 when none of the lines match, and with a greater status
+// (and an error message) when they fail
+func noMatches(err error, stderr *stderrTail) bool {
+	exitErr, ok := err.(*exec.ExitError)
+	return ok && exitErr.ExitCode() == 1 && stderr.LastLine() == ""
+}
+
+// commandError annotates the error returned by an external command
+// with what the command wrote to its standard error
+func commandError(err error, stderr *stderrTail) error {
+	if msg := stderr.LastLine(); msg != "" {
+		return errors.Errorf("%s: %s", err, msg)
+	}
+	return err
+}
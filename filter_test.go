@@ -0,0 +1,374 @@
+package peco
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowFilter matches every line, but takes longer to process
+// the lines that come first, so that later chunks finish first
+type slowFilter struct {
+	total int
+}
+
+func (sf *slowFilter) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	for _, l := range lines {
+		time.Sleep(time.Duration(sf.total-int(l.ID())) * time.Millisecond)
+		out.Send(l)
+	}
+	return nil
+}
+
+func (sf *slowFilter) BufSize() int {
+	return 1
+}
+
+func (sf *slowFilter) NewContext(ctx context.Context, _ string) context.Context {
+	return ctx
+}
+
+func (sf *slowFilter) String() string {
+	return "Slow"
+}
+
+func (sf *slowFilter) Concurrent() bool {
+	return true
+}
+
+var _ filter.Filter = (*slowFilter)(nil)
+
+// serialFilter matches every line, and counts how many times Apply
+// was called at the same time
+type serialFilter struct {
+	slowFilter
+	mutex   sync.Mutex
+	running int
+	max     int
+}
+
+func (sf *serialFilter) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	sf.mutex.Lock()
+	sf.running++
+	if sf.running > sf.max {
+		sf.max = sf.running
+	}
+	sf.mutex.Unlock()
+
+	defer func() {
+		sf.mutex.Lock()
+		sf.running--
+		sf.mutex.Unlock()
+	}()
+	return sf.slowFilter.Apply(ctx, lines, out)
+}
+
+func (sf *serialFilter) Concurrent() bool {
+	return false
+}
+
+func TestAcceptAndFilterSerial(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const total = 20
+	sf := &serialFilter{slowFilter: slowFilter{total: total}}
+	in := make(chan interface{})
+	out := make(chan interface{})
+	go acceptAndFilter(ctx, newFilterProcessor(sf, "", nil), in, pipeline.ChanOutput(out))
+	go func() {
+		for i := 0; i < total; i++ {
+			in <- line.NewRaw(uint64(i), strconv.Itoa(i), false)
+		}
+		in <- pipeline.EndMark{}
+	}()
+
+	var received int
+	for {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("unexpected timeout")
+		case v := <-out:
+			if err, ok := v.(error); ok && pipeline.IsEndMark(err) {
+				if !assert.Equal(t, total, received, "all lines are received") {
+					return
+				}
+				sf.mutex.Lock()
+				defer sf.mutex.Unlock()
+				assert.Equal(t, 1, sf.max, "filters that are not concurrent should be applied to one chunk at a time")
+				return
+			}
+			received++
+		}
+	}
+}
+
+func TestAcceptAndFilterOrder(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const total = 20
+	in := make(chan interface{})
+	out := make(chan interface{})
+	go acceptAndFilter(ctx, newFilterProcessor(&slowFilter{total: total}, "", nil), in, pipeline.ChanOutput(out))
+	go func() {
+		for i := 0; i < total; i++ {
+			in <- line.NewRaw(uint64(i), strconv.Itoa(i), false)
+		}
+		in <- pipeline.EndMark{}
+	}()
+
+	var actual []uint64
+	for {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("unexpected timeout")
+		case v := <-out:
+			if err, ok := v.(error); ok && pipeline.IsEndMark(err) {
+				if !assert.Len(t, actual, total, "all lines are received") {
+					return
+				}
+				for i, id := range actual {
+					if !assert.Equal(t, uint64(i), id, "lines are received in source order") {
+						return
+					}
+				}
+				return
+			}
+			actual = append(actual, v.(line.Line).ID())
+		}
+	}
+}
+
+func TestFilterCache(t *testing.T) {
+	src := &Source{}
+	ic := filter.NewIgnoreCase()
+	rx := filter.NewRegexp()
+
+	fc := newFilterCache(2)
+	foo := NewMemoryBuffer()
+	fo := NewMemoryBuffer()
+	fo.lines = []line.Line{line.NewRaw(0, "foo", false), line.NewRaw(1, "fob", false)}
+
+	fc.Set(src, ic, "fo", fo)
+	fc.Set(src, ic, "foo", foo)
+	if !assert.Equal(t, foo, fc.Get(src, ic, "foo"), "cached result is returned") {
+		return
+	}
+	if !assert.Nil(t, fc.Get(src, rx, "foo"), "results are per filter") {
+		return
+	}
+	if !assert.Nil(t, fc.Get(&Source{}, ic, "foo"), "results are per source") {
+		return
+	}
+
+	if !assert.Equal(t, foo, fc.Narrowest(src, ic, "foob"), "smallest result is used to narrow down") {
+		return
+	}
+	if !assert.Nil(t, fc.Narrowest(src, ic, "bar"), "unrelated queries are not narrowed down") {
+		return
+	}
+	if !assert.Nil(t, fc.Narrowest(src, rx, "foob"), "Regexp can not be narrowed down") {
+		return
+	}
+
+	// "fo" was the least recently used, so it gets evicted
+	fc.Set(src, ic, "bar", NewMemoryBuffer())
+	if !assert.Nil(t, fc.Get(src, ic, "fo"), "least recently used result is evicted") {
+		return
+	}
+	if !assert.NotNil(t, fc.Get(src, ic, "foo"), "recently used result is kept") {
+		return
+	}
+}
+
+func TestBufferSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	raw := line.NewRaw(0, "foo", false)
+	mb := NewMemoryBuffer()
+	mb.lines = []line.Line{
+		line.NewMatched(raw, [][]int{{0, 1}}),
+		line.NewScored(line.NewRaw(1, "bar", false), [][]int{{0, 1}}, 10),
+	}
+
+	out := make(chan interface{})
+	go bufferSource{buf: mb}.Start(ctx, pipeline.ChanOutput(out))
+
+	var actual []line.Line
+	for {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("unexpected timeout")
+		case v := <-out:
+			if err, ok := v.(error); ok && pipeline.IsEndMark(err) {
+				if !assert.Len(t, actual, 2, "all lines are sent") {
+					return
+				}
+				if !assert.Equal(t, raw, actual[0], "matched lines are unwrapped") {
+					return
+				}
+				if !assert.IsType(t, &line.Raw{}, actual[1], "scored lines are unwrapped") {
+					return
+				}
+				return
+			}
+			actual = append(actual, v.(line.Line))
+		}
+	}
+}
+
+// failingFilter fails for every chunk of lines
+type failingFilter struct {
+	slowFilter
+}
+
+func (ff *failingFilter) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	return errors.New("filter is broken")
+}
+
+func TestFilterProcessorError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fp := newFilterProcessor(&failingFilter{}, "foo", nil)
+	in := make(chan interface{})
+	out := make(chan interface{})
+	go fp.Accept(ctx, in, pipeline.ChanOutput(out))
+	go func() {
+		in <- line.NewRaw(0, "foo", false)
+		in <- pipeline.EndMark{}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("unexpected timeout")
+		case v := <-out:
+			err, ok := v.(error)
+			if !assert.True(t, ok, "only the end mark is sent") {
+				return
+			}
+			if !assert.True(t, pipeline.IsEndMark(err), "only the end mark is sent") {
+				return
+			}
+			// The error must be available by the time the end mark
+			// is received
+			if !assert.EqualError(t, fp.Err(), "filter is broken", "error is recorded") {
+				return
+			}
+			return
+		}
+	}
+}
+
+func TestFilterProcessorScope(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	splitter, _ := line.NewFieldSplitter("")
+	ranges, _ := line.ParseFieldRanges("2")
+	f := filter.NewIgnoreCase()
+	ctx = f.NewContext(ctx, "root")
+
+	fp := newFilterProcessor(f, "root", line.NewFieldScope(splitter, ranges))
+	in := make(chan interface{})
+	out := make(chan interface{})
+	go fp.Accept(ctx, in, pipeline.ChanOutput(out))
+	go func() {
+		for i, s := range []string{"root 1 /bin/sh", "www root /bin/root"} {
+			in <- line.NewRaw(uint64(i), s, false)
+		}
+		in <- pipeline.EndMark{}
+	}()
+
+	var actual []line.Line
+	for {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("unexpected timeout")
+		case v := <-out:
+			if err, ok := v.(error); ok && pipeline.IsEndMark(err) {
+				if !assert.Len(t, actual, 1, "only the line with 'root' in the second field matches") {
+					return
+				}
+				if !assert.Equal(t, "www root /bin/root", actual[0].DisplayString(), "original line is returned") {
+					return
+				}
+				if !assert.Equal(t, [][]int{{4, 8}}, actual[0].(*line.Matched).Indices(), "indices point to the second field") {
+					return
+				}
+				return
+			}
+			actual = append(actual, v.(line.Line))
+		}
+	}
+}
+
+func TestFilterJSONMatchScore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The match template is not the displayed text, so the matches
+	// are not highlighted, but the lines must still be scored
+	jf, err := line.NewJSONFormat("{{.path}} {{.name}}", "{{.name}}", "")
+	if !assert.NoError(t, err, "NewJSONFormat should succeed") {
+		return
+	}
+	src := NewMemoryBuffer()
+	for i, v := range []string{
+		`{"name": "xfxxb", "path": "/a"}`,
+		`{"name": "foo-bar", "path": "/b"}`,
+		`{"name": "fb", "path": "/long/path"}`,
+	} {
+		src.lines = append(src.lines, jf.NewLine(uint64(i), v, nil, nil))
+	}
+
+	f := filter.NewScoredFuzzy()
+	buf := NewMemoryBuffer()
+	buf.SetRankFunc(f.RankFunc())
+
+	p := pipeline.New()
+	p.SetSource(bufferSource{buf: src})
+	p.Add(newFilterProcessor(f, "fb", nil))
+	p.SetDestination(buf)
+	if !assert.NoError(t, p.Run(f.NewContext(ctx, "fb")), "pipeline should succeed") {
+		return
+	}
+
+	var ids []uint64
+	for i := 0; i < buf.Size(); i++ {
+		l, err := buf.LineAt(i)
+		if !assert.NoError(t, err, "LineAt should succeed") {
+			return
+		}
+		sl, ok := l.(*line.Scored)
+		if !assert.True(t, ok, "line %d should be scored, got %T", i, l) {
+			return
+		}
+		if !assert.True(t, sl.Score() > 0, "line %d should have a score", i) {
+			return
+		}
+		if !assert.Empty(t, sl.Indices(), "matches in the match template should not be highlighted") {
+			return
+		}
+		ids = append(ids, l.ID())
+	}
+	// Lines are ordered by their score, not by the length of the
+	// displayed text
+	assert.Equal(t, []uint64{2, 1, 0}, ids, "lines should be ordered by score")
+}
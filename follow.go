@@ -0,0 +1,113 @@
+package peco
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/lestrrat-go/pdebug"
+)
+
+// followInterval is how often a followed file is checked for new lines
+const followInterval = 250 * time.Millisecond
+
+// newFollowReader creates a reader that reads f, which was opened from
+// path, and keeps reading what is written to it after reaching its end,
+// like tail -F. The file is checked for changes every interval, until
+// ctx is canceled. If the file is truncated, it is read again from the
+// start. If another file is created at path, such as when logs are
+// rotated, the new file is read after the rest of the old one
+func newFollowReader(ctx context.Context, f *os.File, path string, interval time.Duration) *followReader {
+	return &followReader{
+		ctx:      ctx,
+		file:     f,
+		interval: interval,
+		path:     path,
+	}
+}
+
+func (r *followReader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.current().Read(b)
+		if n > 0 {
+			r.offset += int64(n)
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		if r.truncated() || r.rotated() {
+			continue
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+func (r *followReader) current() *os.File {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file
+}
+
+// truncated rewinds the file if it has become shorter than what has
+// been read from it
+func (r *followReader) truncated() bool {
+	f := r.current()
+	fi, err := f.Stat()
+	if err != nil || fi.Size() >= r.offset {
+		return false
+	}
+
+	if pdebug.Enabled {
+		pdebug.Printf("followReader: %s was truncated", r.path)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	r.offset = 0
+	return true
+}
+
+// rotated switches to the file at path if it is not the file that is
+// being read. The file may not exist for a while during the rotation,
+// in which case the old file is kept
+func (r *followReader) rotated() bool {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+	cur, err := r.current().Stat()
+	if err != nil || os.SameFile(fi, cur) {
+		return false
+	}
+
+	f, err := os.Open(r.path)
+	if err != nil {
+		return false
+	}
+
+	if pdebug.Enabled {
+		pdebug.Printf("followReader: %s was rotated", r.path)
+	}
+	r.mutex.Lock()
+	old := r.file
+	r.file = f
+	r.mutex.Unlock()
+	old.Close()
+	r.offset = 0
+	return true
+}
+
+// Close closes the file that is being read
+func (r *followReader) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Close()
+}
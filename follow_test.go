@@ -0,0 +1,164 @@
+package peco
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollowReader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "peco-follow")
+	if !assert.NoError(t, err, "creating a directory should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	if !assert.NoError(t, ioutil.WriteFile(path, []byte("a\nb\n"), 0644), "writing the file should succeed") {
+		return
+	}
+	f, err := os.Open(path)
+	if !assert.NoError(t, err, "opening the file should succeed") {
+		return
+	}
+
+	r := newFollowReader(ctx, f, path, 10*time.Millisecond)
+	defer r.Close()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	expect := func(name string, expected ...string) bool {
+		for _, v := range expected {
+			select {
+			case <-ctx.Done():
+				return assert.Fail(t, "timed out waiting for a line", "%s: expected %s", name, v)
+			case l := <-lines:
+				if !assert.Equal(t, v, l, "%s: lines should match", name) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	appendFile := func(path, s string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		f.WriteString(s)
+	}
+
+	if !expect("Existing lines", "a", "b") {
+		return
+	}
+
+	appendFile(path, "c\n")
+	if !expect("Appended lines", "c") {
+		return
+	}
+
+	if !assert.NoError(t, ioutil.WriteFile(path, []byte("d\n"), 0644), "truncating the file should succeed") {
+		return
+	}
+	if !expect("Truncated file", "d") {
+		return
+	}
+
+	// The rest of the old file is read before the new file
+	if !assert.NoError(t, os.Rename(path, path+".1"), "renaming the file should succeed") {
+		return
+	}
+	appendFile(path+".1", "e\n")
+	if !assert.NoError(t, ioutil.WriteFile(path, []byte("f\n"), 0644), "creating the file should succeed") {
+		return
+	}
+	if !expect("Rotated file", "e", "f") {
+		return
+	}
+
+	cancel()
+	select {
+	case _, ok := <-lines:
+		assert.False(t, ok, "reading should stop when the context is canceled")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "reading did not stop")
+	}
+}
+
+func TestFollow(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "peco-follow")
+	if !assert.NoError(t, err, "creating a directory should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	if !assert.NoError(t, ioutil.WriteFile(path, []byte("info: started\nerror: disk full\n"), 0644), "writing the file should succeed") {
+		return
+	}
+
+	screen := NewVirtualScreen(40, 6)
+	defer screen.Close()
+
+	resultCh := make(chan []string, 1)
+	go func() {
+		selected, _ := SelectReader(ctx, strings.NewReader(""), Options{
+			Args:   []string{"--follow", "--query", "error", path},
+			Screen: screen,
+		})
+		resultCh <- selected
+	}()
+
+	if !assert.NoError(t, screen.WaitForText(ctx, "error: disk full"), "existing lines should be displayed") {
+		return
+	}
+
+	// The query picks up the lines that are written to the file
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if !assert.NoError(t, err, "opening the file should succeed") {
+		return
+	}
+	f.WriteString("info: retrying\nerror: timeout\n")
+	f.Close()
+
+	err = screen.WaitFor(ctx, func(s *VirtualScreen) bool {
+		return strings.Contains(s.Snapshot(), "error: timeout")
+	})
+	if !assert.NoError(t, err, "new lines should be displayed") {
+		return
+	}
+	if !assert.NotContains(t, screen.Snapshot(), "info: retrying", "new lines should be filtered") {
+		return
+	}
+
+	if !assert.NoError(t, screen.SendKeys("Enter"), "keys should be sent") {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		t.Errorf("peco did not exit")
+	case selected := <-resultCh:
+		assert.Equal(t, []string{"error: disk full"}, selected, "selected line should be output")
+	}
+}
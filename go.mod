@@ -3,6 +3,7 @@ module github.com/peco/peco
 go 1.12
 
 require (
+	github.com/gdamore/tcell/v2 v2.7.0
 	github.com/google/btree v1.1.2
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/lestrrat-go/pdebug v0.0.0-20180220043849-39f9a71bcabe
@@ -11,4 +12,6 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/rivo/uniseg v0.4.4 // indirect
 	github.com/stretchr/testify v1.8.4
+	golang.org/x/sys v0.15.0
+	golang.org/x/term v0.15.0
 )
@@ -43,6 +43,7 @@ func New(bufsiz int) *Hub {
 		drawCh:      make(chan Payload, bufsiz),
 		statusMsgCh: make(chan Payload, bufsiz),
 		pagingCh:    make(chan Payload, bufsiz),
+		eventCh:     make(chan Payload, bufsiz),
 	}
 }
 
@@ -195,3 +196,16 @@ func (h *Hub) PagingCh() chan Payload {
 func (h *Hub) SendPaging(ctx context.Context, x interface{}) {
 	send(ctx, h.PagingCh(), NewPayload(x, isBatchCtx(ctx)))
 }
+
+// EventCh returns the channel to notify that something has happened,
+// such as the input having been read to the end
+func (h *Hub) EventCh() chan Payload {
+	return h.eventCh
+}
+
+// SendEvent sends an event to be handled along with the user's input.
+// This is usually the name of an event, so that the actions bound to
+// it are executed, but it may also be an action to execute
+func (h *Hub) SendEvent(ctx context.Context, ev interface{}) {
+	send(ctx, h.EventCh(), NewPayload(ev, isBatchCtx(ctx)))
+}
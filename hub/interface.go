@@ -12,6 +12,7 @@ type Hub struct {
 	drawCh      chan Payload
 	statusMsgCh chan Payload
 	pagingCh    chan Payload
+	eventCh     chan Payload
 }
 
 // Payload is a wrapper around the actual request value that needs
@@ -28,6 +28,17 @@ func (i *Input) Loop(ctx context.Context, cancel func()) error {
 			if err := i.handleInputEvent(ctx, ev); err != nil {
 				return nil
 			}
+		case payload := <-i.state.Hub().EventCh():
+			// Actions bound to events, as well as the ones sent by
+			// remote clients, are executed here, so that they do not
+			// interleave with the ones bound to keys
+			switch v := payload.Data().(type) {
+			case string:
+				i.state.Keymap().ExecuteEvent(ctx, i.state, v)
+			case Action:
+				v.Execute(context.WithValue(ctx, isTopLevelActionCall, true), i.state, termbox.Event{})
+			}
+			payload.Done()
 		}
 	}
 }
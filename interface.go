@@ -1,12 +1,15 @@
 package peco
 
 import (
+	"container/list"
 	"io"
+	"os"
 	"sync"
 	"time"
 
 	"context"
 
+	"github.com/gdamore/tcell/v2"
 	"github.com/google/btree"
 	"github.com/nsf/termbox-go"
 	"github.com/peco/peco/filter"
@@ -14,6 +17,7 @@ import (
 	"github.com/peco/peco/internal/keyseq"
 	"github.com/peco/peco/line"
 	"github.com/peco/peco/pipeline"
+	"golang.org/x/term"
 )
 
 const (
@@ -31,6 +35,11 @@ const (
 	ToLineInPage                               // ToLineInPage jumps to a particular line on the page
 	ToScrollFirstItem                          // ToScrollFirstItem
 	ToScrollLastItem                           // ToScrollLastItem
+	ToPreviewLineUp                            // ToPreviewLineUp scrolls the preview up by a line
+	ToPreviewLineDown                          // ToPreviewLineDown scrolls the preview down by a line
+	ToPreviewPageUp                            // ToPreviewPageUp scrolls the preview up by a page
+	ToPreviewPageDown                          // ToPreviewPageDown scrolls the preview down by a page
+	ToLineNumber                               // ToLineNumber moves the selection to a particular line in the buffer
 )
 
 const (
@@ -39,6 +48,23 @@ const (
 	LayoutTypeBottomUp = "bottom-up"
 )
 
+const (
+	ScreenTypeTermbox = "termbox" // ScreenTypeTermbox draws the screen with termbox. This is the default
+	ScreenTypeTcell   = "tcell"   // ScreenTypeTcell draws the screen with tcell, which supports 24-bit colors
+)
+
+const (
+	EventLoad      = "Load"      // EventLoad happens when the input has been read to the end
+	EventChange    = "Change"    // EventChange happens when the query changes
+	EventZeroMatch = "ZeroMatch" // EventZeroMatch happens when a query finishes without matching any lines
+	EventOneMatch  = "OneMatch"  // EventOneMatch happens when a query finishes matching exactly one line
+)
+
+const (
+	PreviewPositionRight  = "right"  // PreviewPositionRight places the preview to the right of the list
+	PreviewPositionBottom = "bottom" // PreviewPositionBottom places the preview after the list
+)
+
 const (
 	AnchorTop    VerticalAnchor = iota + 1 // AnchorTop anchors elements towards the top of the screen
 	AnchorBottom                           // AnchorBottom anchors elements towards the bottom of the screen
@@ -105,11 +131,44 @@ type Peco struct {
 	styles                  StyleSet
 	use256Color             bool
 	fuzzyLongestSort        bool
+	fuzzyScoreSort          bool
+	fieldScope              *line.FieldScope
+	filterMode              bool
+	filterQuery             string
+	displayFields           *line.FieldScope
+	outputFields            *line.FieldScope
+	preview                 PreviewConfig
+	ansi                    bool
+	keepANSI                bool
+	jsonFormat              *line.JSONFormat
+	tableFormat             *line.TableFormat
+	header                  []line.Line // given by --header
+	follow                  bool
+	headerLines             int
+
+	// These are given via Options when peco is used as a library.
+	// customConfig is used instead of the config file
+	customConfig  *Config
+	customFilters []filter.Filter
+	customActions map[string]Action
 
 	// Source is where we buffer input. It gets reused when a new query is
 	// executed.
 	source *Source
 
+	// sourceCmd is the command that produces the source, if any.
+	// sourceCancel stops it
+	sourceCmd    string
+	sourceCancel func()
+
+	// listenPath is the path of the socket that remote clients
+	// connect to, if any
+	listenPath string
+
+	// lastQuery is the query that was last executed, so that we can
+	// tell when it changes
+	lastQuery string
+
 	// cancelFunc is called for Exit()
 	cancelFunc func()
 	// Errors are stored here
@@ -141,6 +200,10 @@ type PagingRequest interface {
 
 type JumpToLineRequest int
 
+// LineNumberRequest moves the selection to the line at the given index
+// of the current buffer
+type LineNumberRequest int
+
 // Selection stores the line ids that were selected by the user.
 // The contents of the Selection is always sorted from smallest to
 // largest line ID
@@ -165,6 +228,57 @@ type Screen interface {
 	Suspend()
 }
 
+// VirtualScreen is a Screen that draws onto a grid of cells in memory
+// instead of the terminal, and receives its events from the program.
+// It is meant for testing peco (or programs that embed it) end to end
+type VirtualScreen struct {
+	mutex   sync.Mutex
+	width   int
+	height  int
+	back    []virtualCell // cells drawn since the last flush
+	front   []virtualCell // cells that were flushed
+	cursorX int
+	cursorY int
+	events  chan termbox.Event
+	flushCh chan struct{} // closed on each flush
+	done    chan struct{} // closed when the screen is closed
+}
+
+type virtualCell struct {
+	ch rune // 0 for the right half of a wide character
+	fg termbox.Attribute
+	bg termbox.Attribute
+}
+
+// InlineScreen draws peco in a few lines below the cursor, instead of
+// taking over the whole terminal, so that what was displayed before
+// stays where it is. It talks to the terminal with escape sequences
+type InlineScreen struct {
+	mutex     sync.Mutex
+	height    int  // height that was asked for, in lines or in percent
+	percent   bool // true if height is a percentage of the terminal
+	tty       *os.File
+	out       io.Writer
+	ttyState  *term.State
+	width     int
+	rows      int           // number of lines that peco is drawn in
+	back      []virtualCell // cells drawn since the last flush
+	front     []virtualCell // cells that are on the terminal
+	redraw    bool          // true if front does not match the terminal
+	cursorX   int
+	cursorY   int
+	suspended bool
+	resumed   chan struct{} // closed when the screen is resumed
+	done      chan struct{} // closed when the screen is closed
+}
+
+// Tcell hands out the processing to the tcell library, which unlike
+// termbox can display 24-bit colors alongside the palette colors
+type Tcell struct {
+	mutex  sync.Mutex
+	screen tcell.Screen
+}
+
 // Termbox just hands out the processing to the termbox library
 type Termbox struct {
 	mutex     sync.Mutex
@@ -233,6 +347,18 @@ type ListArea struct {
 	displayCache []line.Line
 	dirty        bool
 	styles       *StyleSet
+	headerLines  int
+	columnWidths []int
+}
+
+// HeaderArea displays the header lines, which are not filtered. It is
+// between the prompt and the list in the top-down layout, and above
+// the list in the bottom-up layout
+type HeaderArea struct {
+	*AnchorSettings
+	list        *ListArea
+	sortTopDown bool
+	styles      *StyleSet
 }
 
 // BasicLayout is... the basic layout :) At this point this is the
@@ -241,8 +367,92 @@ type ListArea struct {
 // that are used are set and static
 type BasicLayout struct {
 	*StatusBar
-	prompt *UserPrompt
-	list   *ListArea
+	prompt  *UserPrompt
+	header  *HeaderArea
+	list    *ListArea
+	preview *PreviewArea // nil if there is no preview
+}
+
+// Options configures peco when it is used as a library, through
+// Select or SelectReader
+type Options struct {
+	// Args are the command line options, such as "--query=foo". Note
+	// that options that print to the standard output, such as
+	// --print-query, have no effect
+	Args []string
+
+	// Config is used instead of the config file, which is never read
+	// by the library. It should be initialized with Config.Init before
+	// it is modified. If nil, the defaults are used
+	Config *Config
+
+	// Screen is where peco is displayed. If nil, the terminal is used
+	Screen Screen
+
+	// Filters are added after the built-in filters, and can be
+	// selected by their names, which are returned by their String
+	// methods
+	Filters []filter.Filter
+
+	// Actions are made available under the given names, such as
+	// "myapp.Open", so that they can be bound to keys in Config.Keymap
+	Actions map[string]Action
+}
+
+// remoteRequest is a request from a client that is connected to the
+// socket given to --listen
+type remoteRequest struct {
+	// Type is either "action", to execute an action, or "state", to
+	// get the current state
+	Type string `json:"type"`
+	// Action is the name of the action, as in the config file
+	Action string `json:"action"`
+	// Args are the arguments given to the action, if any. Each of them
+	// must be a string or a number
+	Args []interface{} `json:"args"`
+}
+
+// remoteResponse is sent back to the client for each request
+type remoteResponse struct {
+	OK    bool         `json:"ok"`
+	Error string       `json:"error,omitempty"`
+	State *remoteState `json:"state,omitempty"`
+}
+
+// remoteState describes the current state of peco for remote clients
+type remoteState struct {
+	Query     string   `json:"query"`
+	Filter    string   `json:"filter"`
+	Total     int      `json:"total"`     // number of lines in the input
+	Matched   int      `json:"matched"`   // number of lines that matched the query
+	Current   string   `json:"current"`   // the line under the cursor
+	Selection []string `json:"selection"` // the selected lines
+}
+
+// remoteStateRequest asks the View for the state of peco. The View
+// fills it in between drawing the screen, as drawing moves the cursor
+type remoteStateRequest struct {
+	state remoteState
+}
+
+// PreviewArea displays the output of a command that is run for
+// the line under the cursor
+type PreviewArea struct {
+	screen   Screen
+	styles   *StyleSet
+	command  string
+	position string
+	size     int
+	border   VerticalAnchor // zero if the border is on the left
+
+	mutex      sync.Mutex
+	cancel     func() // cancels the command that is running
+	generation uint64 // incremented each time the command is run
+	hasLine    bool
+	lineID     uint64 // ID of the line that the command was run for
+	lines      []string
+	offset     int // number of lines scrolled
+	height     int // number of lines that were drawn the last time
 }
 
 // Keymap holds all the key sequence to action map
@@ -250,13 +460,40 @@ type Keymap struct {
 	Config map[string]string
 	Action map[string][]string // custom actions
 	seq    Keyseq
+	events map[string]Action // actions bound to events
+	custom map[string]Action // actions given via Options
 }
 
 // Filter is responsible for the actual "grep" part of peco
 type Filter struct {
+	cache *filterCache
 	state *Peco
 }
 
+// filterCache is a small LRU cache of the results of recent queries.
+// It is used to bring back the result of a query without running it
+// again (e.g. when the user hits backspace), and as the source of
+// queries that narrow down the result of a previous one
+type filterCache struct {
+	capacity int
+	entries  *list.List
+	mutex    sync.Mutex
+}
+
+type filterCacheEntry struct {
+	source pipeline.Source
+	filter filter.Filter
+	query  string
+	buf    *MemoryBuffer
+}
+
+// bufferSource is a pipeline.Source that replays the lines in a
+// Buffer, so that the result of a previous query can be filtered
+// instead of the entire Source
+type bufferSource struct {
+	buf Buffer
+}
+
 // Action describes an action that can be executed upon receiving user
 // input. It's an interface so you can create any kind of Action you need,
 // but most everything is implemented in terms of ActionFunc, which is
@@ -301,6 +538,11 @@ type Config struct {
 	StickySelection     bool
 	MaxScanBufferSize   int
 	FuzzyLongestSort    bool
+	FuzzyScoreSort      bool
+	Delimiter           string
+	Nth                 string
+	WithNth             string
+	OutputNth           string
 
 	// If this is true, then the prefix for single key jump mode
 	// is displayed by default.
@@ -308,6 +550,51 @@ type Config struct {
 
 	// Use this prefix to denote currently selected line
 	SelectionPrefix string `json:"SelectionPrefix"`
+
+	Preview PreviewConfig `json:"Preview"`
+
+	// Event maps the names of events, such as "Load", to the actions
+	// that are executed when they happen
+	Event map[string][]string `json:"Event"`
+
+	// Screen is the name of the library used to draw the screen,
+	// "termbox" or "tcell"
+	Screen string `json:"Screen"`
+
+	// JSON specifies the templates that are used for JSON input
+	JSON JSONConfig `json:"JSON"`
+}
+
+// JSONConfig specifies the templates (in the syntax of Go's
+// text/template) that choose what is displayed, matched against and
+// output when each line of the input is a JSON object (--json). The
+// object is the dot in the templates, so that "{{.name}}" is its
+// "name" member
+type JSONConfig struct {
+	// Display is the text that is displayed. The default is the line
+	Display string `json:"Display"`
+
+	// Match is the text that queries are matched against. The default
+	// is the displayed text
+	Match string `json:"Match"`
+
+	// Output is the text that is output. The default is the line
+	Output string `json:"Output"`
+}
+
+// PreviewConfig specifies the command whose output is displayed in
+// the preview area, and where it is displayed
+type PreviewConfig struct {
+	// Command is run via the shell each time the cursor moves to
+	// another line. "{}" is replaced by the line's output, quoted
+	Command string `json:"Command"`
+
+	// Position is either "right" (the default) or "bottom"
+	Position string `json:"Position"`
+
+	// Size is the percentage of the width (or height) of the list
+	// area that the preview takes. The default is 50
+	Size int `json:"Size"`
 }
 
 type SingleKeyJumpConfig struct {
@@ -331,6 +618,22 @@ type CustomFilterConfig struct {
 	// more often, but you pay the penalty of invoking that command
 	// more times.
 	BufferThreshold int
+
+	// Format is the format of the input and output of the command,
+	// either "text" (the default) or "json". See
+	// filter.ExternalCmd.SetFormat
+	Format string
+
+	// Timeout is the number of milliseconds that each invocation of
+	// the command may take before it is killed. Zero means that there
+	// is no timeout
+	Timeout int
+
+	// Persistent makes peco start the command only once, and keep it
+	// running. The command receives batches of lines to filter as
+	// JSON on its standard input, and replies with the IDs of the
+	// matching lines. See filter.ExternalCmd.SetPersistent
+	Persistent bool
 }
 
 // StyleSet holds styles for various sections
@@ -340,6 +643,7 @@ type StyleSet struct {
 	Selected       Style `json:"Selected"`
 	Query          Style `json:"Query"`
 	Matched        Style `json:"Matched"`
+	Header         Style `json:"Header"`
 }
 
 // Style describes termbox styles
@@ -375,18 +679,37 @@ type FilterQuery Query
 type Source struct {
 	pipeline.ChanOutput
 
-	capacity   int
-	enableSep  bool
-	idgen      line.IDGenerator
-	in         io.Reader
-	inClosed   bool
-	isInfinite bool
-	lines      []line.Line
-	name       string
-	mutex      sync.RWMutex
-	ready      chan struct{}
-	setupDone  chan struct{}
-	setupOnce  sync.Once
+	ansi          bool
+	capacity      int
+	displayFields *line.FieldScope
+	enableSep     bool
+	header        []line.Line
+	headerLines   int
+	idgen         line.IDGenerator
+	in            io.Reader
+	inClosed      bool
+	isInfinite    bool
+	jsonFormat    *line.JSONFormat
+	keepANSI      bool
+	lines         []line.Line
+	name          string
+	mutex         sync.RWMutex
+	outputFields  *line.FieldScope
+	ready         chan struct{}
+	setupDone     chan struct{}
+	setupOnce     sync.Once
+	tableFormat   *line.TableFormat
+}
+
+// followReader reads a file, and keeps reading what is written to it
+// after reaching its end. See newFollowReader
+type followReader struct {
+	ctx      context.Context
+	file     *os.File
+	interval time.Duration
+	mutex    sync.Mutex
+	offset   int64
+	path     string
 }
 
 type State interface {
@@ -402,22 +725,44 @@ type State interface {
 }
 
 type CLIOptions struct {
-	OptHelp            bool   `short:"h" long:"help" description:"show this help message and exit"`
-	OptQuery           string `long:"query" description:"initial value for query"`
-	OptRcfile          string `long:"rcfile" description:"path to the settings file"`
-	OptVersion         bool   `long:"version" description:"print the version and exit"`
-	OptBufferSize      int    `long:"buffer-size" short:"b" description:"number of lines to keep in search buffer"`
-	OptEnableNullSep   bool   `long:"null" description:"expect NUL (\\0) as separator for target/output"`
-	OptInitialIndex    int    `long:"initial-index" description:"position of the initial index of the selection (0 base)"`
-	OptInitialMatcher  string `long:"initial-matcher" description:"specify the default matcher (deprecated)"`
-	OptInitialFilter   string `long:"initial-filter" description:"specify the default filter"`
-	OptPrompt          string `long:"prompt" description:"specify the prompt string"`
-	OptLayout          string `long:"layout" description:"layout to be used. 'top-down' or 'bottom-up'. default is 'top-down'"`
-	OptSelect1         bool   `long:"select-1" description:"select first item and immediately exit if the input contains only 1 item"`
-	OptOnCancel        string `long:"on-cancel" description:"specify action on user cancel. 'success' or 'error'.\ndefault is 'success'. This may change in future versions"`
-	OptSelectionPrefix string `long:"selection-prefix" description:"use a prefix instead of changing line color to indicate currently selected lines.\ndefault is to use colors. This option is experimental"`
-	OptExec            string `long:"exec" description:"execute command instead of finishing/terminating peco.\nPlease note that this command will receive selected line(s) from stdin,\nand will be executed via '/bin/sh -c' or 'cmd /c'"`
-	OptPrintQuery      bool   `long:"print-query" description:"print out the current query as first line of output"`
+	OptHelp            bool    `short:"h" long:"help" description:"show this help message and exit"`
+	OptQuery           string  `long:"query" description:"initial value for query"`
+	OptRcfile          string  `long:"rcfile" description:"path to the settings file"`
+	OptVersion         bool    `long:"version" description:"print the version and exit"`
+	OptBufferSize      int     `long:"buffer-size" short:"b" description:"number of lines to keep in search buffer"`
+	OptEnableNullSep   bool    `long:"null" description:"expect NUL (\\0) as separator for target/output"`
+	OptInitialIndex    int     `long:"initial-index" description:"position of the initial index of the selection (0 base)"`
+	OptInitialMatcher  string  `long:"initial-matcher" description:"specify the default matcher (deprecated)"`
+	OptInitialFilter   string  `long:"initial-filter" description:"specify the default filter"`
+	OptPrompt          string  `long:"prompt" description:"specify the prompt string"`
+	OptLayout          string  `long:"layout" description:"layout to be used. 'top-down' or 'bottom-up'. default is 'top-down'"`
+	OptSelect1         bool    `long:"select-1" description:"select first item and immediately exit if the input contains only 1 item"`
+	OptOnCancel        string  `long:"on-cancel" description:"specify action on user cancel. 'success' or 'error'.\ndefault is 'success'. This may change in future versions"`
+	OptSelectionPrefix string  `long:"selection-prefix" description:"use a prefix instead of changing line color to indicate currently selected lines.\ndefault is to use colors. This option is experimental"`
+	OptExec            string  `long:"exec" description:"execute command instead of finishing/terminating peco.\nPlease note that this command will receive selected line(s) from stdin,\nand will be executed via '/bin/sh -c' or 'cmd /c'"`
+	OptPrintQuery      bool    `long:"print-query" description:"print out the current query as first line of output"`
+	OptDelimiter       string  `long:"delimiter" description:"regular expression used to split lines into fields.\ndefault is to split by white space"`
+	OptNth             string  `long:"nth" description:"comma separated list of field ranges to match against (e.g. '2..', '1,-1')"`
+	OptWithNth         string  `long:"with-nth" description:"comma separated list of field ranges to display"`
+	OptOutputNth       string  `long:"output-nth" description:"comma separated list of field ranges to output.\ndefault is to output the entire line"`
+	OptFilter          *string `long:"filter" description:"print the lines that match the given query and exit.\nthe interactive screen is not used"`
+	OptPreview         string  `long:"preview" description:"command to preview the current line with.\n'{}' is replaced by the line"`
+	OptPreviewPosition string  `long:"preview-position" description:"position of the preview. 'right' or 'bottom'.\ndefault is 'right'"`
+	OptPreviewSize     int     `long:"preview-size" description:"percentage of the list area that the preview takes.\ndefault is 50"`
+	OptSourceCmd       string  `long:"source-cmd" description:"command whose output is used as input.\nif it contains '{q}', it is replaced by the query, and the command\nis executed again each time the query changes"`
+	OptListen          string  `long:"listen" description:"path of a Unix domain socket to accept remote commands on"`
+	OptANSI            bool    `long:"ansi" description:"display the colors given by ANSI escape sequences in the input"`
+	OptKeepANSI        bool    `long:"keep-ansi" description:"with --ansi, keep the ANSI escape sequences in the output"`
+	OptJSON            bool    `long:"json" description:"read each line as a JSON object, and display and output it as\nthe templates in the config file (or the --json-* options) say"`
+	OptJSONDisplay     string  `long:"json-display" description:"with --json, template for the text to display, such as '{{.name}}'"`
+	OptJSONMatch       string  `long:"json-match" description:"with --json, template for the text to match queries against.\ndefault is the displayed text"`
+	OptJSONOutput      string  `long:"json-output" description:"with --json, template for the text to output.\ndefault is the entire line"`
+	OptTable           string  `long:"table" description:"read the input as a table, 'tsv' or 'csv', and display it with aligned\ncolumns. the first line is the header"`
+	OptHeaderLines     int     `long:"header-lines" description:"display the first N lines of the input as a header, which is not filtered"`
+	OptHeader          string  `long:"header" description:"display the given text as a header"`
+	OptFollow          bool    `long:"follow" description:"keep reading the file as it grows, like 'tail -F'"`
+	OptHeight          string  `long:"height" description:"display peco in the given number of lines (or percentage of the terminal,\nsuch as '40%') below the cursor, instead of using the whole terminal"`
+	OptScreen          string  `long:"screen" description:"library used to draw the screen. 'termbox' or 'tcell'.\ndefault is 'termbox'"`
 }
 
 type CLI struct {
@@ -442,6 +787,7 @@ type MemoryBuffer struct {
 	lines        []line.Line
 	mutex        sync.RWMutex
 	PeriodicFunc func()
+	rank         func(a, b line.Line) bool
 }
 
 type ActionMap interface {
@@ -462,10 +808,12 @@ type Input struct {
 type MessageHub interface {
 	Batch(context.Context, func(context.Context), bool)
 	DrawCh() chan hub.Payload
+	EventCh() chan hub.Payload
 	PagingCh() chan hub.Payload
 	QueryCh() chan hub.Payload
 	SendDraw(context.Context, interface{})
 	SendDrawPrompt(context.Context)
+	SendEvent(context.Context, interface{})
 	SendPaging(context.Context, interface{})
 	SendQuery(context.Context, string)
 	SendStatusMsg(context.Context, string)
@@ -474,6 +822,18 @@ type MessageHub interface {
 }
 
 type filterProcessor struct {
+	err    error
 	filter filter.Filter
+	mutex  sync.Mutex
 	query  string
+	scope  *line.FieldScope
+}
+
+// filterJob is a chunk of lines that is filtered by one of the
+// filter workers
+type filterJob struct {
+	done    chan struct{}
+	err     error
+	lines   []line.Line
+	results []interface{}
 }
@@ -2,7 +2,11 @@
 
 package util
 
-import "os/exec"
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
 
 func Shell(cmd ...string) *exec.Cmd {
 	const shellpath = `/bin/sh`
@@ -16,3 +20,18 @@ func Shell(cmd ...string) *exec.Cmd {
 	
 	return exec.Command(shellpath, args...)
 }
+
+// ShellQuote quotes s so that the shell treats it as a single word
+func ShellQuote(s string) string {
+	return `'` + strings.Replace(s, `'`, `'\''`, -1) + `'`
+}
+
+// OpenTerminal opens the controlling terminal for reading and writing.
+// Both of the returned files refer to the same terminal
+func OpenTerminal() (*os.File, *os.File, error) {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f, nil
+}
@@ -2,7 +2,11 @@
 
 package util
 
-import "os/exec"
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
 
 func Shell(cmd ...string) *exec.Cmd {
 	const shellpath = `cmd`
@@ -16,3 +20,30 @@ func Shell(cmd ...string) *exec.Cmd {
 	
 	return exec.Command(shellpath, args...)
 }
+
+// cmdQuoter escapes the characters that cmd still interprets inside
+// double quotes. A double quote is doubled, which ends and restarts the
+// quoted text, so what follows it stays quoted. A percent sign, which
+// would expand a variable even inside quotes, is caret escaped outside
+// of the quotes
+var cmdQuoter = strings.NewReplacer(`"`, `""`, `%`, `"^%"`)
+
+// ShellQuote quotes s so that cmd treats it as a single argument, and
+// does not run any of the commands or expand any of the variables in it
+func ShellQuote(s string) string {
+	return `"` + cmdQuoter.Replace(s) + `"`
+}
+
+// OpenTerminal opens the console for reading and writing
+func OpenTerminal() (*os.File, *os.File, error) {
+	in, err := os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := os.OpenFile("CONOUT$", os.O_RDWR, 0)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
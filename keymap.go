@@ -3,7 +3,9 @@ package peco
 import (
 	"context"
 	"sort"
+	"strconv"
 	"strings"
+	"text/scanner"
 	"time"
 	"github.com/lestrrat-go/pdebug"
 	"github.com/nsf/termbox-go"
@@ -117,6 +119,11 @@ func (km Keymap) resolveActionName(name string, depth int) (Action, error) {
 		return v, nil
 	}
 
+	// Was it given via Options?
+	if v, ok := km.custom[name]; ok {
+		return v, nil
+	}
+
 	// Can it be resolved via combined actions?
 	l, ok := km.Action[name]
 	if ok {
@@ -133,9 +140,107 @@ func (km Keymap) resolveActionName(name string, depth int) (Action, error) {
 		return v, nil
 	}
 
+	// Is it an action that takes an argument?
+	if i := strings.IndexByte(name, '('); i > 0 {
+		return resolveParameterizedAction(name[:i], name[i:])
+	}
+
 	return nil, errors.Errorf("could not resolve %s: no such action", name)
 }
 
+// resolveParameterizedAction creates the action for a call such as
+// peco.Execute("command") or peco.JumpToLine(10)
+func resolveParameterizedAction(name, args string) (Action, error) {
+	create, ok := nameToParameterizedActions[name]
+	if !ok {
+		return nil, errors.Errorf("could not resolve %s: no such action", name)
+	}
+
+	values, err := parseActionArgs(args)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not resolve %s", name)
+	}
+
+	a, err := create(values)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not resolve %s", name)
+	}
+	return a, nil
+}
+
+// parseActionArgs parses the argument list of a parameterized action,
+// such as ("foo", 10). Each argument is either a quoted string (using
+// " or `), which is returned as a string, or an integer, which is
+// returned as an int
+func parseActionArgs(s string) ([]interface{}, error) {
+	var sc scanner.Scanner
+	sc.Init(strings.NewReader(s))
+	sc.Mode = scanner.ScanInts | scanner.ScanStrings | scanner.ScanRawStrings
+
+	var scanErr error
+	sc.Error = func(_ *scanner.Scanner, msg string) {
+		if scanErr == nil {
+			scanErr = errors.New(msg)
+		}
+	}
+
+	if sc.Scan() != '(' {
+		return nil, errors.New("missing '('")
+	}
+
+	var args []interface{}
+	tok := sc.Scan()
+	for tok != ')' {
+		if len(args) > 0 {
+			if tok != ',' {
+				return nil, unexpectedToken(&sc, tok)
+			}
+			tok = sc.Scan()
+		}
+
+		negative := tok == '-'
+		if negative {
+			tok = sc.Scan()
+		}
+
+		switch {
+		case tok == scanner.Int:
+			n, err := strconv.Atoi(sc.TokenText())
+			if err != nil {
+				return nil, errors.Errorf("invalid number %s", sc.TokenText())
+			}
+			if negative {
+				n = -n
+			}
+			args = append(args, n)
+		case !negative && (tok == scanner.String || tok == scanner.RawString):
+			v, err := strconv.Unquote(sc.TokenText())
+			if err != nil {
+				return nil, errors.Errorf("invalid string %s", sc.TokenText())
+			}
+			args = append(args, v)
+		default:
+			return nil, unexpectedToken(&sc, tok)
+		}
+		tok = sc.Scan()
+	}
+
+	if tok = sc.Scan(); tok != scanner.EOF {
+		return nil, errors.Errorf("unexpected %s after ')'", sc.TokenText())
+	}
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return args, nil
+}
+
+func unexpectedToken(sc *scanner.Scanner, tok rune) error {
+	if tok == scanner.EOF {
+		return errors.New("missing ')'")
+	}
+	return errors.Errorf("unexpected %s at column %d", sc.TokenText(), sc.Position.Column)
+}
+
 // ApplyKeybinding applies all of the custom key bindings on top of
 // the default key bindings
 func (km *Keymap) ApplyKeybinding() error {
@@ -184,6 +289,52 @@ func (km *Keymap) ApplyKeybinding() error {
 	return errors.Wrap(k.Compile(), "failed to compile key binding patterns")
 }
 
+// ApplyEvents resolves the names of the actions that are bound to
+// each event. Like combined actions, the actions for an event are
+// executed in order
+func (km *Keymap) ApplyEvents(events map[string][]string) error {
+	km.events = map[string]Action{}
+	for name, l := range events {
+		switch name {
+		case EventLoad, EventChange, EventZeroMatch, EventOneMatch:
+		default:
+			return errors.Errorf("unknown event %s", name)
+		}
+
+		actions := []Action{}
+		for _, actionName := range l {
+			a, err := km.resolveActionName(actionName, 0)
+			if err != nil {
+				return errors.Wrapf(err, "failed to resolve action name %s for event %s", actionName, name)
+			}
+			actions = append(actions, a)
+		}
+		km.events[name] = makeCombinedAction(actions...)
+	}
+	return nil
+}
+
+func (km Keymap) hasEventActions(name string) bool {
+	_, ok := km.events[name]
+	return ok
+}
+
+// ExecuteEvent executes the actions that are bound to the event
+func (km Keymap) ExecuteEvent(ctx context.Context, state *Peco, name string) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Keymap.ExecuteEvent %s", name)
+		defer g.End()
+	}
+
+	a, ok := km.events[name]
+	if !ok {
+		return
+	}
+
+	ctx = context.WithValue(ctx, isTopLevelActionCall, true)
+	a.Execute(ctx, state, termbox.Event{})
+}
+
 // TODO: this needs to be fixed.
 func (km Keymap) hasModifierMaps() bool {
 	return false
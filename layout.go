@@ -2,6 +2,7 @@ package peco
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -367,6 +368,17 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 	buf := pf.Crop(linebuf)
 	bufsiz := buf.Size()
 
+	// The header and the rows of a table are aligned to the widest
+	// cells on the page, so every line must be drawn again when the
+	// widths or the number of header lines change
+	header := state.Header()
+	widths := columnWidths(header, buf)
+	if len(header) != l.headerLines || !intsEqual(widths, l.columnWidths) {
+		l.headerLines = len(header)
+		l.columnWidths = widths
+		l.SetDirty(true)
+	}
+
 	// This protects us from losing the selected line in case our selected
 	// line is greater than the buffer
 	if lbufsiz := linebuf.Size(); lbufsiz > 0 && loc.LineNumber() >= lbufsiz {
@@ -377,7 +389,11 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 	// loc variable thinks we should be scrolling to, and make sure that this
 	// falls in range with what we got
 	width, _ := state.screen.Size()
-	if max := maxOf(buf.MaxColumn()-width, 0); loc.Column() > max {
+	maxColumn := buf.MaxColumn()
+	if widths != nil {
+		maxColumn = tableWidth(widths)
+	}
+	if max := maxOf(maxColumn-width, 0); loc.Column() > max {
 		loc.SetColumn(max)
 	}
 
@@ -394,6 +410,12 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 	var y int
 	start := l.AnchorPosition()
 
+	// In the top-down layout, the header is between the prompt and
+	// the list
+	if l.sortTopDown {
+		start += len(header)
+	}
+
 	// If our buffer is smaller than perPage, we may need to
 	// clear some lines
 	if pdebug.Enabled {
@@ -526,6 +548,24 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 			x += 2
 		}
 
+		if columns := lineColumns(target); len(columns) > 0 {
+			var matches [][]int
+			if ix, ok := target.(MatchIndexer); ok {
+				matches = ix.Indices()
+			}
+			l.drawColumns(x, y, xOffset, line, fgAttr, bgAttr, matches, columns, widths)
+			continue
+		}
+
+		if spans := lineSpans(target); len(spans) > 0 {
+			var matches [][]int
+			if ix, ok := target.(MatchIndexer); ok {
+				matches = ix.Indices()
+			}
+			l.drawSpans(x, y, xOffset, line, fgAttr, bgAttr, matches, spans)
+			continue
+		}
+
 		ix, ok := target.(MatchIndexer)
 		if !ok {
 			l.screen.Print(PrintArgs{
@@ -602,6 +642,267 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 	}
 }
 
+// drawSpans draws a line that has styles from the input, and fills
+// the rest of the line
+func (l *ListArea) drawSpans(x, y, xOffset int, text string, fg, bg termbox.Attribute, matches [][]int, spans []line.StyleSpan) {
+	x = l.drawParts(x, y, xOffset, text, fg, bg, matches, spans)
+	l.screen.Print(PrintArgs{
+		X:       x,
+		Y:       y,
+		XOffset: xOffset,
+		Fg:      fg,
+		Bg:      bg,
+		Fill:    true,
+	})
+}
+
+// drawParts draws text that may have matches and styles from the
+// input, and returns the column after it. The text is split where the
+// spans and the matches start and end, and each part is drawn in the
+// style of the line, with the style of the span and then the Matched
+// style applied on top of it
+func (l *ListArea) drawParts(x, y, xOffset int, text string, fg, bg termbox.Attribute, matches [][]int, spans []line.StyleSpan) int {
+	cuts := []int{0, len(text)}
+	for _, sp := range spans {
+		cuts = append(cuts, sp.Start, sp.End)
+	}
+	for _, m := range matches {
+		cuts = append(cuts, m[0], m[1])
+	}
+	sort.Ints(cuts)
+
+	for i := 0; i+1 < len(cuts); i++ {
+		start, end := cuts[i], cuts[i+1]
+		if start >= end || end > len(text) {
+			continue
+		}
+
+		partFg, partBg := fg, bg
+		for _, sp := range spans {
+			if sp.Start <= start && end <= sp.End {
+				partFg, partBg = mergeSpanStyle(fg, bg, sp)
+				break
+			}
+		}
+		for _, m := range matches {
+			if m[0] <= start && end <= m[1] {
+				partFg = l.styles.Matched.fg
+				partBg = mergeAttribute(partBg, l.styles.Matched.bg)
+				break
+			}
+		}
+
+		x += l.screen.Print(PrintArgs{
+			X:       x,
+			Y:       y,
+			XOffset: xOffset,
+			Fg:      partFg,
+			Bg:      partBg,
+			Msg:     text[start:end],
+		})
+	}
+	return x
+}
+
+// drawColumns draws a row of a table, padding each cell to the width
+// of its column, and fills the rest of the line. Matches are only
+// highlighted in the cells that they are in
+func (l *ListArea) drawColumns(x, y, xOffset int, text string, fg, bg termbox.Attribute, matches [][]int, columns [][]int, widths []int) {
+	for i, c := range columns {
+		if i > 0 {
+			x += l.screen.Print(PrintArgs{
+				X:       x,
+				Y:       y,
+				XOffset: xOffset,
+				Fg:      fg,
+				Bg:      bg,
+				Msg:     columnSeparator,
+			})
+		}
+
+		var cellMatches [][]int
+		for _, m := range matches {
+			start, end := maxOf(m[0], c[0]), m[1]
+			if end > c[1] {
+				end = c[1]
+			}
+			if start < end {
+				cellMatches = append(cellMatches, []int{start - c[0], end - c[0]})
+			}
+		}
+
+		next := l.drawParts(x, y, xOffset, text[c[0]:c[1]], fg, bg, cellMatches, nil)
+		if i < len(columns)-1 && i < len(widths) && next-x < widths[i] {
+			next += l.screen.Print(PrintArgs{
+				X:       next,
+				Y:       y,
+				XOffset: xOffset,
+				Fg:      fg,
+				Bg:      bg,
+				Msg:     strings.Repeat(" ", widths[i]-(next-x)),
+			})
+		}
+		x = next
+	}
+
+	l.screen.Print(PrintArgs{
+		X:       x,
+		Y:       y,
+		XOffset: xOffset,
+		Fg:      fg,
+		Bg:      bg,
+		Fill:    true,
+	})
+}
+
+// columnSeparator is drawn between the columns of a table
+const columnSeparator = "  "
+
+// columnWidths returns the widths of the columns of the rows of a
+// table in the header and in buf, or nil if there are none
+func columnWidths(header []line.Line, buf *FilteredBuffer) []int {
+	var widths []int
+	measure := func(l line.Line) {
+		text := l.DisplayString()
+		for i, c := range lineColumns(l) {
+			w := runewidth.StringWidth(text[c[0]:c[1]])
+			if i >= len(widths) {
+				widths = append(widths, w)
+			} else if w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	for _, hl := range header {
+		measure(hl)
+	}
+	for i := 0; i < buf.Size(); i++ {
+		if l, err := buf.LineAt(i); err == nil {
+			measure(l)
+		}
+	}
+	return widths
+}
+
+// tableWidth returns the width of the rows of a table whose columns
+// have the given widths
+func tableWidth(widths []int) int {
+	w := len(columnSeparator) * (len(widths) - 1)
+	for _, v := range widths {
+		w += v
+	}
+	return w
+}
+
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lineColumns returns the cells of a line, if it is a row of a table
+func lineColumns(l line.Line) [][]int {
+	if cl, ok := l.(line.Columnar); ok {
+		return cl.Columns()
+	}
+	return nil
+}
+
+// lineSpans returns the styled parts of a line, if it has any
+func lineSpans(l line.Line) []line.StyleSpan {
+	if sl, ok := l.(line.Styled); ok {
+		return sl.Spans()
+	}
+	return nil
+}
+
+// mergeSpanStyle applies the style of a span on top of the style of
+// the line. The colors of the span are used, except for the background
+// of lines that have one, such as the selected line, so that they
+// still stand out. Attributes such as bold are combined
+func mergeSpanStyle(fg, bg termbox.Attribute, sp line.StyleSpan) (termbox.Attribute, termbox.Attribute) {
+	const attrs = rgbColorBase - 1 - 0x1ff
+	if color := sp.Fg &^ attrs; color != termbox.ColorDefault {
+		fg = color | fg&attrs
+	}
+	fg |= sp.Fg & attrs
+
+	if color := sp.Bg &^ attrs; color != termbox.ColorDefault && bg&^attrs == termbox.ColorDefault {
+		bg = color | bg&attrs
+	}
+	return fg, bg
+}
+
+// NewHeaderArea creates a new HeaderArea. The columns of tables in the
+// header are aligned with those in list
+func NewHeaderArea(screen Screen, anchor VerticalAnchor, anchorOffset int, list *ListArea, styles *StyleSet) *HeaderArea {
+	return &HeaderArea{
+		AnchorSettings: NewAnchorSettings(screen, anchor, anchorOffset),
+		list:           list,
+		sortTopDown:    list.sortTopDown,
+		styles:         styles,
+	}
+}
+
+// Draw displays the header lines. perPage is the number of lines in
+// the list, which the header is above in the bottom-up layout. The
+// header is indented by the width of the prefixes of the lines in the
+// list, so that the columns of tables line up
+func (h *HeaderArea) Draw(state *Peco, perPage int) {
+	header := state.Header()
+	if len(header) == 0 {
+		return
+	}
+
+	y := h.AnchorPosition()
+	if !h.sortTopDown {
+		y -= perPage + len(header) - 1
+	}
+
+	loc := state.Location()
+	indent := 0
+	if n := len(state.selectionPrefix); n > 0 {
+		indent += n + 1
+	}
+	if state.SingleKeyJumpMode() || state.SingleKeyJumpShowPrefix() {
+		indent += 2
+	}
+
+	fg, bg := h.styles.Header.fg, h.styles.Header.bg
+	for i, hl := range header {
+		h.screen.Print(PrintArgs{
+			X:       -1 * loc.Column(),
+			Y:       y + i,
+			XOffset: loc.Column(),
+			Fg:      fg,
+			Bg:      bg,
+			Msg:     strings.Repeat(" ", indent),
+		})
+
+		x := -1*loc.Column() + indent
+		if columns := lineColumns(hl); len(columns) > 0 {
+			h.list.drawColumns(x, y+i, loc.Column(), hl.DisplayString(), fg, bg, nil, columns, h.list.columnWidths)
+			continue
+		}
+		h.screen.Print(PrintArgs{
+			X:       x,
+			Y:       y + i,
+			XOffset: loc.Column(),
+			Fg:      fg,
+			Bg:      bg,
+			Msg:     hl.DisplayString(),
+			Fill:    true,
+		})
+	}
+}
+
 func maxOf(a, b int) int {
 	if a > b {
 		return a
@@ -611,28 +912,43 @@ func maxOf(a, b int) int {
 
 // NewDefaultLayout creates a new Layout in the default format (top-down)
 func NewDefaultLayout(state *Peco) *BasicLayout {
+	// The list area is at the top, after the prompt and the header
+	// It's also displayed top-to-bottom order
+	list := NewListArea(state.Screen(), AnchorTop, 1, true, state.Styles())
 	return &BasicLayout{
 		StatusBar: NewStatusBar(state.Screen(), AnchorBottom, 0+extraOffset, state.Styles()),
 		// The prompt is at the top
 		prompt: NewUserPrompt(state.Screen(), AnchorTop, 0, state.Prompt(), state.Styles()),
-		// The list area is at the top, after the prompt
-		// It's also displayed top-to-bottom order
-		list: NewListArea(state.Screen(), AnchorTop, 1, true, state.Styles()),
+		// The header is after the prompt
+		header:  NewHeaderArea(state.Screen(), AnchorTop, 1, list, state.Styles()),
+		list:    list,
+		preview: newPreviewArea(state, true),
 	}
 }
 
 // NewBottomUpLayout creates a new Layout in bottom-up format
 func NewBottomUpLayout(state *Peco) *BasicLayout {
+	// The list area is at the bottom, above the prompt
+	// It's displayed in bottom-to-top order
+	list := NewListArea(state.Screen(), AnchorBottom, 2+extraOffset, false, state.Styles())
 	return &BasicLayout{
 		StatusBar: NewStatusBar(state.Screen(), AnchorBottom, 0+extraOffset, state.Styles()),
 		// The prompt is at the bottom, above the status bar
 		prompt: NewUserPrompt(state.Screen(), AnchorBottom, 1+extraOffset, state.Prompt(), state.Styles()),
-		// The list area is at the bottom, above the prompt
-		// It's displayed in bottom-to-top order
-		list: NewListArea(state.Screen(), AnchorBottom, 2+extraOffset, false, state.Styles()),
+		// The header is above the list, wherever the list ends
+		header:  NewHeaderArea(state.Screen(), AnchorBottom, 2+extraOffset, list, state.Styles()),
+		list:    list,
+		preview: newPreviewArea(state, false),
 	}
 }
 
+func newPreviewArea(state *Peco, sortTopDown bool) *PreviewArea {
+	if state.preview.Command == "" {
+		return nil
+	}
+	return NewPreviewArea(state.Screen(), state.preview, sortTopDown, state.Styles())
+}
+
 func (l *BasicLayout) PurgeDisplayCache() {
 	l.list.purgeDisplayCache()
 }
@@ -643,6 +959,10 @@ func (l *BasicLayout) CalculatePage(state *Peco, perPage int) error {
 		g := pdebug.Marker("BasicLayout.Calculate %d", perPage)
 		defer g.End()
 	}
+	// The page is calculated when the lines have changed, and the
+	// cursor may now be on a different line
+	defer l.updatePreview(state)
+
 	buf := state.CurrentLineBuffer()
 	loc := state.Location()
 	loc.SetPage((loc.LineNumber() / perPage) + 1)
@@ -679,7 +999,7 @@ func (l *BasicLayout) DrawScreen(state *Peco, options *DrawOptions) {
 		defer g.End()
 	}
 
-	perPage := l.linesPerPage()
+	perPage := l.linesPerPage(state)
 
 	if err := l.CalculatePage(state, perPage); err != nil {
 		return
@@ -687,18 +1007,60 @@ func (l *BasicLayout) DrawScreen(state *Peco, options *DrawOptions) {
 
 	l.DrawPrompt(state)
 	l.list.Draw(state, l, perPage, options)
+	l.header.Draw(state, perPage)
+	if l.preview != nil {
+		l.drawPreview(perPage + len(state.Header()))
+	}
 
 	if err := l.screen.Flush(); err != nil {
 		return
 	}
 }
 
-func (l *BasicLayout) linesPerPage() int {
+// drawPreview draws the preview in the part of the list area that
+// is not used by the list. listLines includes the lines of the header
+func (l *BasicLayout) drawPreview(listLines int) {
+	width, height := l.screen.Size()
+	total := height - 2 - extraOffset
+	if total < 1 {
+		return
+	}
+
+	if l.preview.position == PreviewPositionBottom {
+		lines := total - listLines
+		if lines < 2 {
+			return
+		}
+		y := 0
+		if l.list.sortTopDown {
+			y = 1 + listLines
+		}
+		l.preview.Draw(0, y, width, lines)
+		return
+	}
+
+	// There must be room for the border, and at least one column
+	// for both the list and the preview
+	cols := width * l.preview.size / 100
+	if cols < 2 || width-cols < 1 {
+		return
+	}
+	y := 0
+	if l.list.sortTopDown {
+		y = 1
+	}
+	l.preview.Draw(width-cols, y, cols, total)
+}
+
+func (l *BasicLayout) linesPerPage(state *Peco) int {
 	_, height := l.screen.Size()
 
-	// list area is always the display area - 2 lines for prompt and status
-	reservedLines := 2 + extraOffset
+	// list area is always the display area - 2 lines for prompt and status,
+	// minus the lines used by the header, and by the preview if it is
+	// placed after the list
+	reservedLines := 2 + extraOffset + len(state.Header())
 	pp := height - reservedLines
+	pp -= l.preview.Lines(pp)
 	if pp < 1 {
 		// This is an error condition, and while we probably should handle this
 		// error more gracefully, the consumers of this method do not really
@@ -721,12 +1083,25 @@ func (l *BasicLayout) MovePage(state *Peco, p PagingRequest) (moved bool) {
 	switch p.Type() {
 	case ToScrollLeft, ToScrollRight:
 		moved = horizontalScroll(state, l, p)
+	case ToPreviewLineUp, ToPreviewLineDown, ToPreviewPageUp, ToPreviewPageDown:
+		moved = previewScroll(l, p)
 	default:
 		moved = verticalScroll(state, l, p)
+		if moved {
+			l.updatePreview(state)
+		}
 	}
 	return
 }
 
+// updatePreview runs the preview command for the line that the cursor
+// is on, if it is not already shown
+func (l *BasicLayout) updatePreview(state *Peco) {
+	if l.preview != nil {
+		l.preview.Update(state)
+	}
+}
+
 // verticalScroll moves the cursor position vertically
 func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 	// Before we move, on which line were we located?
@@ -751,7 +1126,7 @@ func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 		}
 	}()
 
-	lpp := l.linesPerPage()
+	lpp := l.linesPerPage(state)
 	if l.list.sortTopDown {
 		switch p.Type() {
 		case ToLineAbove:
@@ -787,6 +1162,15 @@ func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 		}
 	}
 
+	if p.Type() == ToLineNumber {
+		// The same in both layouts. Lines past the end of the buffer
+		// take us to the last line, instead of wrapping around
+		lineno = p.(LineNumberRequest).Line()
+		if lcur > 0 && lineno >= lcur {
+			lineno = lcur - 1
+		}
+	}
+
 	if lineno < 0 {
 		if lcur > 0 {
 			// Go to last page, if possible
@@ -857,6 +1241,24 @@ func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 	return true
 }
 
+// previewScroll scrolls the preview
+func previewScroll(l *BasicLayout, p PagingRequest) bool {
+	if l.preview == nil {
+		return false
+	}
+
+	switch p.Type() {
+	case ToPreviewLineUp:
+		return l.preview.Scroll(-1, false)
+	case ToPreviewLineDown:
+		return l.preview.Scroll(1, false)
+	case ToPreviewPageUp:
+		return l.preview.Scroll(-1, true)
+	default:
+		return l.preview.Scroll(1, true)
+	}
+}
+
 // horizontalScroll scrolls screen horizontal
 func horizontalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 	width, _ := state.screen.Size()
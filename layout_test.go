@@ -1,11 +1,18 @@
 package peco
 
 import (
+	"context"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 	"unicode/utf8"
 
 	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestLayoutType(t *testing.T) {
@@ -127,3 +134,406 @@ func TestMergeAttribute(t *testing.T) {
 	}
 
 }
+
+// screenText reconstructs the text on the screen from the SetCell
+// events that were recorded by the dummy screen
+func screenText(screen *dummyScreen) []string {
+	w, h := screen.Size()
+	rows := make([][]rune, h)
+	for i := range rows {
+		rows[i] = []rune(strings.Repeat(" ", w))
+	}
+
+	screen.interceptor.m.Lock()
+	defer screen.interceptor.m.Unlock()
+	for _, ev := range screen.interceptor.events["SetCell"] {
+		x, y := ev[0].(int), ev[1].(int)
+		if x < 0 || x >= w || y < 0 || y >= h {
+			continue
+		}
+		rows[y][x] = ev[2].(rune)
+	}
+
+	text := make([]string, h)
+	for i, row := range rows {
+		text[i] = strings.TrimRight(string(row), " ")
+	}
+	return text
+}
+
+func TestPreviewArea(t *testing.T) {
+	newState := func(cfg PreviewConfig, layoutType string) (*Peco, *dummyScreen) {
+		screen := NewDummyScreen()
+		state := New()
+		state.screen = screen
+		state.hub = nullHub{}
+		state.layoutType = layoutType
+		state.preview = cfg
+		state.filters.Add(filter.NewIgnoreCase())
+
+		buf := NewMemoryBuffer()
+		buf.lines = []line.Line{
+			line.NewRaw(0, "foo", false),
+			line.NewRaw(1, "bar", false),
+		}
+		state.SetCurrentLineBuffer(buf)
+		return state, screen
+	}
+
+	// waitPreview waits until the preview command has finished
+	waitPreview := func(pa *PreviewArea) bool {
+		for i := 0; i < 500; i++ {
+			pa.mutex.Lock()
+			done := pa.lines != nil
+			pa.mutex.Unlock()
+			if done {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	t.Run("Right", func(t *testing.T) {
+		state, screen := newState(PreviewConfig{Command: "echo 'preview:'{}; echo second", Position: PreviewPositionRight, Size: 50}, LayoutTypeTopDown)
+		layout := NewDefaultLayout(state)
+
+		layout.DrawScreen(state, nil)
+		if !assert.True(t, waitPreview(layout.preview), "preview command should finish") {
+			return
+		}
+		screen.interceptor.reset()
+		layout.DrawScreen(state, &DrawOptions{DisableCache: true})
+
+		text := screenText(screen)
+		if !assert.Equal(t, "foo"+strings.Repeat(" ", 37)+"│preview:foo", text[1], "preview is drawn to the right of the list") {
+			return
+		}
+		if !assert.Equal(t, "bar"+strings.Repeat(" ", 37)+"│second", text[2], "preview continues on the next line") {
+			return
+		}
+		if !assert.Equal(t, strings.Repeat(" ", 40)+"│", text[3], "rest of the preview is empty") {
+			return
+		}
+
+		// Moving the cursor runs the command again, without redrawing
+		if !assert.True(t, layout.MovePage(state, ToLineBelow), "cursor should move") {
+			return
+		}
+		if !assert.True(t, waitPreview(layout.preview), "preview command should finish") {
+			return
+		}
+		if !assert.Equal(t, []string{"preview:bar", "second"}, layout.preview.lines, "preview is updated") {
+			return
+		}
+
+		if !assert.False(t, layout.MovePage(state, ToPreviewLineDown), "preview that fits cannot be scrolled") {
+			return
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		state, _ := newState(PreviewConfig{Command: "if [ {} = foo ]; then sleep 10; fi; echo {}", Position: PreviewPositionRight, Size: 50}, LayoutTypeTopDown)
+		layout := NewDefaultLayout(state)
+
+		// The command for "foo" is still running when we move on to "bar"
+		layout.DrawScreen(state, nil)
+		state.Location().SetLineNumber(1)
+		layout.DrawScreen(state, nil)
+
+		if !assert.True(t, waitPreview(layout.preview), "preview command should finish") {
+			return
+		}
+		if !assert.Equal(t, []string{"bar"}, layout.preview.lines, "preview shows the current line") {
+			return
+		}
+	})
+
+	t.Run("Scroll", func(t *testing.T) {
+		state, _ := newState(PreviewConfig{Command: "seq 20", Position: PreviewPositionRight, Size: 50}, LayoutTypeTopDown)
+		layout := NewDefaultLayout(state)
+
+		layout.DrawScreen(state, nil)
+		if !assert.True(t, waitPreview(layout.preview), "preview command should finish") {
+			return
+		}
+		layout.DrawScreen(state, nil)
+
+		// 8 lines are displayed, so we can scroll down 12 lines
+		moves := []struct {
+			req      PagingRequestType
+			moved    bool
+			expected int
+		}{
+			{ToPreviewLineDown, true, 1},
+			{ToPreviewPageDown, true, 9},
+			{ToPreviewPageDown, true, 12},
+			{ToPreviewLineDown, false, 12},
+			{ToPreviewLineUp, true, 11},
+			{ToPreviewPageUp, true, 3},
+			{ToPreviewPageUp, true, 0},
+			{ToPreviewLineUp, false, 0},
+		}
+		for _, m := range moves {
+			if !assert.Equal(t, m.moved, layout.MovePage(state, m.req), "MovePage(%s) result", m.req) {
+				return
+			}
+			if !assert.Equal(t, m.expected, layout.preview.offset, "offset after %s", m.req) {
+				return
+			}
+		}
+	})
+
+	t.Run("Bottom", func(t *testing.T) {
+		for _, layoutType := range []string{LayoutTypeTopDown, LayoutTypeBottomUp} {
+			state, screen := newState(PreviewConfig{Command: "echo {}", Position: PreviewPositionBottom, Size: 50}, layoutType)
+			layout := NewView(state).layout.(*BasicLayout)
+
+			// 10 lines, minus the prompt and the status bar
+			if !assert.Equal(t, 4, layout.linesPerPage(state), "preview takes half of the list area (%s)", layoutType) {
+				return
+			}
+
+			layout.DrawScreen(state, nil)
+			if !assert.True(t, waitPreview(layout.preview), "preview command should finish") {
+				return
+			}
+			screen.interceptor.reset()
+			layout.DrawScreen(state, &DrawOptions{DisableCache: true})
+
+			text := screenText(screen)
+			border, first := 5, 6
+			if layoutType == LayoutTypeBottomUp {
+				border, first = 3, 0
+			}
+			if !assert.Equal(t, strings.Repeat("─", 80), text[border], "border is drawn between the list and the preview (%s)", layoutType) {
+				return
+			}
+			if !assert.Equal(t, "foo", text[first], "preview is drawn after the list (%s)", layoutType) {
+				return
+			}
+		}
+	})
+}
+
+func TestMovePageToLineNumber(t *testing.T) {
+	for _, layoutType := range []string{LayoutTypeTopDown, LayoutTypeBottomUp} {
+		state := New()
+		state.screen = NewDummyScreen()
+		state.hub = nullHub{}
+		state.layoutType = layoutType
+		state.filters.Add(filter.NewIgnoreCase())
+
+		buf := NewMemoryBuffer()
+		for i := 0; i < 20; i++ {
+			buf.lines = append(buf.lines, line.NewRaw(uint64(i), strconv.Itoa(i), false))
+		}
+		state.SetCurrentLineBuffer(buf)
+		layout := NewView(state).layout.(*BasicLayout)
+
+		for _, n := range []int{12, 0, 30} {
+			layout.MovePage(state, LineNumberRequest(n))
+			expected := n
+			if expected >= 20 {
+				expected = 19
+			}
+			if !assert.Equal(t, expected, state.Location().LineNumber(), "line number after moving to %d (%s)", n, layoutType) {
+				return
+			}
+		}
+	}
+}
+
+func TestListAreaANSI(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	screen := NewVirtualScreen(20, 5)
+	defer screen.Close()
+
+	resultCh := make(chan []string, 1)
+	go func() {
+		selected, _ := Select(ctx, []string{"\x1b[31mfoo\x1b[m", "\x1b[1;32mbar\x1b[m baz", "\x1b[44mqux\x1b[m"}, Options{
+			Args:   []string{"--ansi", "--query", "a"},
+			Screen: screen,
+		})
+		resultCh <- selected
+	}()
+
+	err := screen.WaitFor(ctx, func(s *VirtualScreen) bool {
+		return strings.Contains(s.Snapshot(), "bar baz") && !strings.Contains(s.Snapshot(), "foo")
+	})
+	if !assert.NoError(t, err, "lines should be displayed") {
+		return
+	}
+
+	lines := strings.Split(screen.StyledSnapshot(), "\n")
+	// The selected line keeps its colors, except for the background, and
+	// the matches are highlighted
+	if !assert.Equal(t, "[green,on_magenta,bold,underline]b[/][cyan,on_magenta]a[/][green,on_magenta,bold,underline]r[/][on_magenta,underline] b[/][cyan,on_magenta]a[/][on_magenta,underline]z             [/]", lines[1], "selected line should match") {
+		return
+	}
+
+	if !assert.NoError(t, screen.SendKeys("Enter"), "keys should be sent") {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		t.Errorf("peco did not exit")
+	case selected := <-resultCh:
+		assert.Equal(t, []string{"bar baz"}, selected, "output should not have escape sequences")
+	}
+}
+
+func TestListAreaTable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, layoutType := range []string{LayoutTypeTopDown, LayoutTypeBottomUp} {
+		screen := NewVirtualScreen(40, 6)
+		defer screen.Close()
+
+		resultCh := make(chan []string, 1)
+		go func() {
+			selected, _ := Select(ctx, []string{"NAME,STATUS,AGE", "web-1,Running,3d", `"db, primary",CrashLoopBackOff,12m`, "cache,Running,1h"}, Options{
+				Args:   []string{"--table", "csv", "--layout", layoutType},
+				Screen: screen,
+			})
+			resultCh <- selected
+		}()
+
+		// The header and the rows are aligned to the widest cells
+		expected := []string{
+			"NAME         STATUS            AGE",
+			"web-1        Running           3d",
+			"db, primary  CrashLoopBackOff  12m",
+			"cache        Running           1h",
+		}
+		err := screen.WaitFor(ctx, func(s *VirtualScreen) bool {
+			return strings.Contains(s.Snapshot(), "cache")
+		})
+		if !assert.NoError(t, err, "lines should be displayed (%s)", layoutType) {
+			return
+		}
+
+		lines := strings.Split(screen.Snapshot(), "\n")
+		if layoutType == LayoutTypeBottomUp {
+			// The header is above the list, which is displayed in
+			// bottom-to-top order
+			expected = []string{expected[0], expected[3], expected[2], expected[1]}
+			lines = lines[:4]
+		} else {
+			lines = lines[1:5]
+		}
+		for i := range lines {
+			lines[i] = strings.TrimRight(lines[i], " ")
+		}
+		if !assert.Equal(t, expected, lines, "table should be aligned (%s)", layoutType) {
+			return
+		}
+
+		// Matches are highlighted in the cells, and the header is not
+		// one of the lines to choose from
+		screen.SendString("crash")
+		err = screen.WaitFor(ctx, func(s *VirtualScreen) bool {
+			return strings.Contains(s.Snapshot(), "CrashLoopBackOff") && !strings.Contains(s.Snapshot(), "cache")
+		})
+		if !assert.NoError(t, err, "lines should be filtered (%s)", layoutType) {
+			return
+		}
+		if !assert.Contains(t, screen.StyledSnapshot(), "[cyan,on_magenta]Crash[/]", "match should be highlighted (%s)", layoutType) {
+			return
+		}
+		if !assert.Contains(t, screen.Snapshot(), "NAME", "header should be displayed (%s)", layoutType) {
+			return
+		}
+
+		if !assert.NoError(t, screen.SendKeys("Enter"), "keys should be sent") {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Errorf("peco did not exit")
+			return
+		case selected := <-resultCh:
+			if !assert.Equal(t, []string{`"db, primary",CrashLoopBackOff,12m`}, selected, "output should be the entire line (%s)", layoutType) {
+				return
+			}
+		}
+	}
+}
+
+func TestHeaderArea(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, layoutType := range []string{LayoutTypeTopDown, LayoutTypeBottomUp} {
+		screen := NewVirtualScreen(20, 8)
+		defer screen.Close()
+
+		resultCh := make(chan []string, 1)
+		go func() {
+			selected, _ := Select(ctx, []string{"ID NAME", "1 foo", "2 bar"}, Options{
+				Args:   []string{"--header-lines", "1", "--header", "Pick one", "--layout", layoutType},
+				Screen: screen,
+			})
+			resultCh <- selected
+		}()
+
+		// The header lines are not counted in the total
+		err := screen.WaitFor(ctx, func(s *VirtualScreen) bool {
+			return strings.Contains(s.Snapshot(), "[2 (1/1)]") && strings.Contains(s.Snapshot(), "2 bar")
+		})
+		if !assert.NoError(t, err, "lines should be displayed (%s)", layoutType) {
+			return
+		}
+
+		lines := strings.Split(screen.Snapshot(), "\n")
+		for i := range lines {
+			lines[i] = strings.TrimRight(lines[i], " ")
+		}
+		expected := []string{"Pick one", "ID NAME", "1 foo", "2 bar"}
+		if layoutType == LayoutTypeBottomUp {
+			// The header is above the list, wherever the list ends
+			if !assert.Equal(t, []string{"Pick one", "ID NAME", "", "", "2 bar", "1 foo"}, lines[:6], "header should be above the list (%s)", layoutType) {
+				return
+			}
+		} else if !assert.Equal(t, expected, lines[1:5], "header should be after the prompt (%s)", layoutType) {
+			return
+		}
+
+		// The header lines are not filtered
+		screen.SendString("ID")
+		err = screen.WaitFor(ctx, func(s *VirtualScreen) bool {
+			return strings.Contains(s.Snapshot(), "[0 (1/1)]") && !strings.Contains(s.Snapshot(), "1 foo")
+		})
+		if !assert.NoError(t, err, "lines should be filtered (%s)", layoutType) {
+			return
+		}
+		if !assert.Contains(t, screen.Snapshot(), "ID NAME", "header should still be displayed (%s)", layoutType) {
+			return
+		}
+
+		if !assert.NoError(t, screen.SendKeys("C-u"), "keys should be sent") {
+			return
+		}
+		err = screen.WaitFor(ctx, func(s *VirtualScreen) bool {
+			return strings.Contains(s.Snapshot(), "[2 (1/1)]") && strings.Contains(s.Snapshot(), "1 foo")
+		})
+		if !assert.NoError(t, err, "query should be cleared (%s)", layoutType) {
+			return
+		}
+		if !assert.NoError(t, screen.SendKeys("Enter"), "keys should be sent") {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Errorf("peco did not exit")
+			return
+		case selected := <-resultCh:
+			if !assert.Equal(t, []string{"1 foo"}, selected, "header should not be selected (%s)", layoutType) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package line
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// attributeMask selects the attributes, such as bold, in a termbox
+// attribute, leaving out the color
+const attributeMask = termbox.AttrBold | termbox.AttrBlink | termbox.AttrHidden | termbox.AttrDim | termbox.AttrUnderline | termbox.AttrCursive | termbox.AttrReverse
+
+// NewANSI creates a new ANSI line from the text v. The display and
+// output scopes, and the enableSep flag, work in the same way as they
+// do for NewFielded. If keepEscapes is true, the escape sequences are
+// kept in the Output
+func NewANSI(id uint64, v string, enableSep bool, display, output *FieldScope, keepEscapes bool) *ANSI {
+	rl := NewRaw(id, v, enableSep)
+	text, out := v, v
+	if i := rl.sepLoc; i > -1 {
+		text, out = v[:i], v[i+1:]
+	}
+
+	al := &ANSI{Raw: rl}
+	al.display, al.spans = ParseANSI(text)
+	if display != nil {
+		al.display, al.spans = extractSpans(display, al.display, al.spans)
+	}
+
+	if !keepEscapes {
+		out, _ = ParseANSI(out)
+	}
+	if output != nil {
+		out = output.Extract(out)
+	}
+	al.output = out
+	return al
+}
+
+// DisplayString returns the text without the escape sequences
+func (al ANSI) DisplayString() string {
+	return al.display
+}
+
+// Output returns the text to output, with or without the escape
+// sequences
+func (al ANSI) Output() string {
+	return al.output
+}
+
+// Spans returns the styled parts of the DisplayString
+func (al ANSI) Spans() []StyleSpan {
+	return al.spans
+}
+
+// extractSpans extracts the selected fields of s like
+// FieldScope.Extract does, and moves the spans along with the text
+func extractSpans(fs *FieldScope, s string, spans []StyleSpan) (string, []StyleSpan) {
+	var buf strings.Builder
+	var extracted []StyleSpan
+	for _, p := range fs.Select(s) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		at := buf.Len()
+		for _, sp := range spans {
+			start, end := sp.Start, sp.End
+			if start < p[0] {
+				start = p[0]
+			}
+			if end > p[1] {
+				end = p[1]
+			}
+			if start >= end {
+				continue
+			}
+			extracted = append(extracted, StyleSpan{Start: start - p[0] + at, End: end - p[0] + at, Fg: sp.Fg, Bg: sp.Bg})
+		}
+		buf.WriteString(s[p[0]:p[1]])
+	}
+	return buf.String(), extracted
+}
+
+// ParseANSI removes the escape sequences from s. The parts of the
+// text that SGR sequences (ESC [ ... m) set colors or attributes for
+// are returned as spans. Other sequences are removed without effect
+func ParseANSI(s string) (string, []StyleSpan) {
+	var buf strings.Builder
+	var spans []StyleSpan
+	var fg, bg termbox.Attribute
+	start := 0
+
+	// end ends the span of the current style, if there is one
+	end := func() {
+		if buf.Len() > start && (fg != termbox.ColorDefault || bg != termbox.ColorDefault) {
+			spans = append(spans, StyleSpan{Start: start, End: buf.Len(), Fg: fg, Bg: bg})
+		}
+		start = buf.Len()
+	}
+
+	for i := 0; i < len(s); {
+		if s[i] != 0x1b {
+			buf.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		switch {
+		case i+1 < len(s) && s[i+1] == '[':
+			// CSI sequences end with a byte between @ and ~
+			j := i + 2
+			for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+				j++
+			}
+			if j < len(s) && s[j] == 'm' {
+				end()
+				fg, bg = applySGR(fg, bg, s[i+2:j])
+			}
+			i = j + 1
+		case i+1 < len(s) && s[i+1] == ']':
+			// OSC sequences, such as hyperlinks, end with BEL or ESC \
+			j := i + 2
+			for j < len(s) && s[j] != 0x07 && !(s[j] == 0x1b && j+1 < len(s) && s[j+1] == '\\') {
+				j++
+			}
+			if j < len(s) && s[j] == 0x07 {
+				i = j + 1
+			} else {
+				i = j + 2
+			}
+		default:
+			// Other sequences, such as ESC ( B, are intermediate bytes
+			// followed by a final byte
+			j := i + 1
+			for j < len(s) && s[j] >= 0x20 && s[j] <= 0x2f {
+				j++
+			}
+			i = j + 1
+		}
+	}
+	end()
+	return buf.String(), spans
+}
+
+// applySGR applies the parameters of an SGR sequence to the style
+func applySGR(fg, bg termbox.Attribute, params string) (termbox.Attribute, termbox.Attribute) {
+	setColor := func(a, color termbox.Attribute) termbox.Attribute {
+		return color | a&attributeMask
+	}
+
+	ps := strings.Split(params, ";")
+	for i := 0; i < len(ps); i++ {
+		// An empty parameter is 0
+		n, _ := strconv.Atoi(ps[i])
+		switch {
+		case n == 0:
+			fg, bg = termbox.ColorDefault, termbox.ColorDefault
+		case n == 1:
+			fg |= termbox.AttrBold
+		case n == 4:
+			fg |= termbox.AttrUnderline
+		case n == 7:
+			fg |= termbox.AttrReverse
+		case n == 22:
+			fg &^= termbox.AttrBold
+		case n == 24:
+			fg &^= termbox.AttrUnderline
+		case n == 27:
+			fg &^= termbox.AttrReverse
+		case n >= 30 && n <= 37:
+			fg = setColor(fg, termbox.Attribute(n-30+1))
+		case n == 39:
+			fg = setColor(fg, termbox.ColorDefault)
+		case n >= 40 && n <= 47:
+			bg = setColor(bg, termbox.Attribute(n-40+1))
+		case n == 49:
+			bg = setColor(bg, termbox.ColorDefault)
+		case n >= 90 && n <= 97:
+			// Bright colors are 8-15 in the 256 color palette
+			fg = setColor(fg, termbox.Attribute(n-90+8+1))
+		case n >= 100 && n <= 107:
+			bg = setColor(bg, termbox.Attribute(n-100+8+1))
+		case n == 38 || n == 48:
+			color, used := parseExtendedColor(ps[i+1:])
+			i += used
+			if n == 38 {
+				fg = setColor(fg, color)
+			} else {
+				bg = setColor(bg, color)
+			}
+		}
+	}
+	return fg, bg
+}
+
+// parseExtendedColor parses the parameters that follow 38 or 48, which
+// are either 5 and the number of a color in the 256 color palette, or
+// 2 and the red, green and blue components of a 24-bit color. It
+// returns the number of parameters that were used
+func parseExtendedColor(ps []string) (termbox.Attribute, int) {
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		if n < 0 || n > 255 {
+			return 0
+		}
+		return n
+	}
+
+	switch {
+	case len(ps) >= 2 && ps[0] == "5":
+		return termbox.Attribute(atoi(ps[1]) + 1), 2
+	case len(ps) >= 4 && ps[0] == "2":
+		return termbox.RGBToAttribute(uint8(atoi(ps[1])), uint8(atoi(ps[2])), uint8(atoi(ps[3]))), 4
+	}
+	return termbox.ColorDefault, len(ps)
+}
@@ -0,0 +1,106 @@
+package line
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseANSI(t *testing.T) {
+	testValues := []struct {
+		name   string
+		input  string
+		text   string
+		expect []StyleSpan
+	}{
+		{
+			name:  "Plain text",
+			input: "foo bar",
+			text:  "foo bar",
+		},
+		{
+			name:   "Basic colors",
+			input:  "\x1b[31mfoo\x1b[m \x1b[1;42mbar\x1b[0m",
+			text:   "foo bar",
+			expect: []StyleSpan{{0, 3, termbox.ColorRed, termbox.ColorDefault}, {4, 7, termbox.AttrBold, termbox.ColorGreen}},
+		},
+		{
+			name:   "Attributes are kept when the color changes",
+			input:  "\x1b[4;33mfoo\x1b[39mbar\x1b[24mbaz",
+			text:   "foobarbaz",
+			expect: []StyleSpan{{0, 3, termbox.ColorYellow | termbox.AttrUnderline, termbox.ColorDefault}, {3, 6, termbox.AttrUnderline, termbox.ColorDefault}},
+		},
+		{
+			name:  "Extended colors",
+			input: "\x1b[38;5;208;48;2;32;32;32mfoo\x1b[91mbar",
+			text:  "foobar",
+			expect: []StyleSpan{
+				{0, 3, 208 + 1, termbox.RGBToAttribute(0x20, 0x20, 0x20)},
+				{3, 6, 9 + 1, termbox.RGBToAttribute(0x20, 0x20, 0x20)},
+			},
+		},
+		{
+			name:  "Other sequences are removed",
+			input: "\x1b[Kfoo\x1b]8;;http://example.com\x07bar\x1b]8;;\x1b\\\x1b(Bbaz",
+			text:  "foobarbaz",
+		},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			text, spans := ParseANSI(v.input)
+			if !assert.Equal(t, v.text, text, "escape sequences should be removed") {
+				return
+			}
+			if !assert.Equal(t, v.expect, spans, "spans should match") {
+				return
+			}
+		})
+	}
+}
+
+func TestNewANSI(t *testing.T) {
+	input := "\x1b[32mfoo\x1b[m bar \x1b[34mbaz\x1b[m"
+
+	al := NewANSI(0, input, false, nil, nil, false)
+	if !assert.Equal(t, "foo bar baz", al.DisplayString(), "display string should not have escape sequences") {
+		return
+	}
+	if !assert.Equal(t, "foo bar baz", al.Output(), "output should not have escape sequences") {
+		return
+	}
+	if !assert.Equal(t, input, al.Buffer(), "buffer should be the original text") {
+		return
+	}
+
+	al = NewANSI(0, input, false, nil, nil, true)
+	if !assert.Equal(t, input, al.Output(), "output should keep the escape sequences") {
+		return
+	}
+
+	ranges, err := ParseFieldRanges("1,3")
+	if !assert.NoError(t, err, "ParseFieldRanges should succeed") {
+		return
+	}
+	fs := NewFieldScope(&FieldSplitter{}, ranges)
+	al = NewANSI(0, input, false, fs, nil, false)
+	if !assert.Equal(t, "foo baz", al.DisplayString(), "display string should only have the fields") {
+		return
+	}
+	if !assert.Equal(t, []StyleSpan{{0, 3, termbox.ColorGreen, termbox.ColorDefault}, {4, 7, termbox.ColorBlue, termbox.ColorDefault}}, al.Spans(), "spans should move with the fields") {
+		return
+	}
+
+	al = NewANSI(0, "\x1b[31mfoo\x1b[m\000\x1b[31mbar\x1b[m", true, nil, nil, false)
+	if !assert.Equal(t, "foo", al.DisplayString(), "display string should be before the separator") {
+		return
+	}
+	if !assert.Equal(t, "bar", al.Output(), "output should be after the separator") {
+		return
+	}
+
+	if !assert.Equal(t, al.Spans(), NewMatched(al, nil).Spans(), "matched lines should have the spans of the original line") {
+		return
+	}
+}
@@ -0,0 +1,324 @@
+package line
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// NewFieldSplitter creates a FieldSplitter that splits lines by the
+// given regular expression. If delim is empty, lines are split by
+// white space, ignoring leading and trailing white space, like awk
+// does
+func NewFieldSplitter(delim string) (*FieldSplitter, error) {
+	if delim == "" {
+		return &FieldSplitter{}, nil
+	}
+
+	rx, err := regexp.Compile(delim)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to compile delimiter '%s'", delim)
+	}
+	return &FieldSplitter{delim: rx}, nil
+}
+
+// Split returns the byte offsets of the start and the end of each
+// field in s. The delimiters are not included in the fields
+func (fs *FieldSplitter) Split(s string) [][]int {
+	if fs.delim == nil {
+		var fields [][]int
+		start := -1
+		for i, r := range s {
+			if unicode.IsSpace(r) {
+				if start >= 0 {
+					fields = append(fields, []int{start, i})
+					start = -1
+				}
+				continue
+			}
+			if start < 0 {
+				start = i
+			}
+		}
+		if start >= 0 {
+			fields = append(fields, []int{start, len(s)})
+		}
+		return fields
+	}
+
+	var fields [][]int
+	start := 0
+	for _, m := range fs.delim.FindAllStringIndex(s, -1) {
+		// An empty delimiter would produce a field for each
+		// character. Ignore it at the edges of the string
+		if m[0] == m[1] && (m[0] == 0 || m[0] == len(s)) {
+			continue
+		}
+		fields = append(fields, []int{start, m[0]})
+		start = m[1]
+	}
+	return append(fields, []int{start, len(s)})
+}
+
+// ParseFieldRanges parses a comma separated list of field ranges,
+// such as "1", "2..", "..3", "2..4" or "-1"
+func ParseFieldRanges(s string) ([]FieldRange, error) {
+	var ranges []FieldRange
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			return nil, errors.Errorf("empty field range in '%s'", s)
+		}
+
+		var r FieldRange
+		var err error
+		if i := strings.Index(spec, ".."); i >= 0 {
+			r.Start, err = parseFieldIndex(spec[:i])
+			if err == nil {
+				r.End, err = parseFieldIndex(spec[i+2:])
+			}
+		} else {
+			r.Start, err = parseFieldIndex(spec)
+			if err == nil && r.Start == 0 {
+				err = errors.New("a field number is required")
+			}
+			r.End = r.Start
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid field range '%s'", spec)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseFieldIndex(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse field number '%s'", s)
+	}
+	if n == 0 {
+		return 0, errors.New("fields are numbered from 1")
+	}
+	return n, nil
+}
+
+// Indices returns the 0-based indices of the first and the last
+// field in the range, for a line with n fields. If none of the fields
+// are in the range, ok is false
+func (r FieldRange) Indices(n int) (first, last int, ok bool) {
+	resolve := func(i, open int) int {
+		switch {
+		case i > 0:
+			return i - 1
+		case i < 0:
+			return n + i
+		}
+		return open
+	}
+
+	first = resolve(r.Start, 0)
+	last = resolve(r.End, n-1)
+	if first < 0 {
+		first = 0
+	}
+	if last >= n {
+		last = n - 1
+	}
+	if first > last {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// NewFieldScope creates a FieldScope that selects the fields in the
+// given ranges, after splitting lines with splitter
+func NewFieldScope(splitter *FieldSplitter, ranges []FieldRange) *FieldScope {
+	return &FieldScope{
+		ranges:   ranges,
+		splitter: splitter,
+	}
+}
+
+// Select returns the byte offsets of the parts of s that contain the
+// selected fields, in the order given by the ranges. Adjacent fields
+// are returned as a single part, including the delimiter between them
+func (fs *FieldScope) Select(s string) [][]int {
+	return fs.selectFields(fs.splitter.Split(s))
+}
+
+// Fields returns the 0-based indices of the selected fields, in the
+// order given by the ranges, for a line with n fields
+func (fs *FieldScope) Fields(n int) []int {
+	var indices []int
+	for _, r := range fs.ranges {
+		first, last, ok := r.Indices(n)
+		if !ok {
+			continue
+		}
+		for i := first; i <= last; i++ {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+func (fs *FieldScope) selectFields(fields [][]int) [][]int {
+	var parts [][]int
+	prev := -2
+	for _, i := range fs.Fields(len(fields)) {
+		if i == prev+1 && len(parts) > 0 {
+			parts[len(parts)-1][1] = fields[i][1]
+		} else {
+			parts = append(parts, []int{fields[i][0], fields[i][1]})
+		}
+		prev = i
+	}
+	return parts
+}
+
+// Extract returns the selected fields of s. The parts of s that are
+// not adjacent are joined by a single space
+func (fs *FieldScope) Extract(s string) string {
+	var buf strings.Builder
+	for _, p := range fs.Select(s) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(s[p[0]:p[1]])
+	}
+	return buf.String()
+}
+
+// Apply returns a Scoped line that only contains the selected fields
+// of l. The parts of the line that are not adjacent are joined by a
+// single space. The fields of rows of a table are its columns
+func (fs *FieldScope) Apply(l Line) *Scoped {
+	s := l.DisplayString()
+	sl := &Scoped{Line: l}
+
+	var fields [][]int
+	if cl, ok := l.(Columnar); ok {
+		fields = cl.Columns()
+	} else {
+		fields = fs.splitter.Split(s)
+	}
+
+	var buf strings.Builder
+	for _, p := range fs.selectFields(fields) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		sl.pieces = append(sl.pieces, scopedPiece{
+			at:     buf.Len(),
+			offset: p[0],
+			length: p[1] - p[0],
+		})
+		buf.WriteString(s[p[0]:p[1]])
+	}
+	sl.display = buf.String()
+	return sl
+}
+
+// ScopeText returns a Scoped line that contains the given text instead
+// of the DisplayString of l. Matches are only highlighted if the text is
+// the DisplayString, as otherwise there is no telling which part of the
+// DisplayString (if any) the text came from
+func ScopeText(l Line, text string) *Scoped {
+	sl := &Scoped{Line: l, display: text}
+	if text == l.DisplayString() && text != "" {
+		sl.pieces = []scopedPiece{{at: 0, offset: 0, length: len(text)}}
+	}
+	return sl
+}
+
+// DisplayString returns the selected fields of the original line
+func (sl Scoped) DisplayString() string {
+	return sl.display
+}
+
+// MapIndices maps the indices of matches in the DisplayString of the
+// Scoped line to the indices in the DisplayString of the original
+// line. A match that spans multiple pieces is split at the edges of
+// the pieces
+func (sl Scoped) MapIndices(matches [][]int) [][]int {
+	var mapped [][]int
+	for _, m := range matches {
+		for _, p := range sl.pieces {
+			start, end := m[0], m[1]
+			if start < p.at {
+				start = p.at
+			}
+			if end > p.at+p.length {
+				end = p.at + p.length
+			}
+			if start >= end {
+				continue
+			}
+			mapped = append(mapped, []int{start - p.at + p.offset, end - p.at + p.offset})
+		}
+	}
+	return mapped
+}
+
+// NewFielded creates a new Fielded line from the text v. If display
+// is non-nil, only the fields that it selects are displayed (and
+// matched against). If output is non-nil, only the fields that it
+// selects are output, otherwise the entire text is. The enableSep
+// flag works in the same way as it does for NewRaw, with the fields
+// taken from the respective part of the text
+func NewFielded(id uint64, v string, enableSep bool, display, output *FieldScope) *Fielded {
+	rl := NewRaw(id, v, enableSep)
+	fl := &Fielded{
+		Raw:     rl,
+		display: rl.DisplayString(),
+		output:  rl.Output(),
+	}
+	if display != nil {
+		fl.display = display.Extract(fl.display)
+	}
+	if output != nil {
+		fl.output = output.Extract(fl.output)
+	}
+	return fl
+}
+
+// DisplayString returns the selected fields to display
+func (fl Fielded) DisplayString() string {
+	return fl.display
+}
+
+// Output returns the selected fields to output, or the entire text
+func (fl Fielded) Output() string {
+	return fl.output
+}
+
+// Unscope returns the original line of a line that a filter returned
+// for a Scoped line. If the filter returned a Matched or a Scored
+// line, the indices of the matches are mapped to the original line
+func Unscope(l Line) Line {
+	switch v := l.(type) {
+	case *Scoped:
+		return v.Line
+	case *Scored:
+		if sl, ok := v.Line.(*Scoped); ok {
+			// The score is kept even when none of the matches can
+			// be highlighted, as the lines are ordered by it
+			return NewScored(sl.Line, sl.MapIndices(v.Indices()), v.Score())
+		}
+	case *Matched:
+		if sl, ok := v.Line.(*Scoped); ok {
+			if m := sl.MapIndices(v.Indices()); len(m) > 0 {
+				return NewMatched(sl.Line, m)
+			}
+			return sl.Line
+		}
+	}
+	return l
+}
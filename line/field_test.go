@@ -0,0 +1,199 @@
+package line
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldSplitter(t *testing.T) {
+	testValues := []struct {
+		name   string
+		delim  string
+		input  string
+		expect [][]int
+	}{
+		{
+			name:   "White space",
+			input:  "  foo  bar\tbaz ",
+			expect: [][]int{{2, 5}, {7, 10}, {11, 14}},
+		},
+		{
+			name:   "Delimiter",
+			delim:  ",",
+			input:  "foo,,bar",
+			expect: [][]int{{0, 3}, {4, 4}, {5, 8}},
+		},
+		{
+			name:   "Regular expression",
+			delim:  `\s*:\s*`,
+			input:  "foo : bar:baz",
+			expect: [][]int{{0, 3}, {6, 9}, {10, 13}},
+		},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			fs, err := NewFieldSplitter(v.delim)
+			if !assert.NoError(t, err, "NewFieldSplitter should succeed") {
+				return
+			}
+			if !assert.Equal(t, v.expect, fs.Split(v.input), "fields are split as expected") {
+				return
+			}
+		})
+	}
+}
+
+func TestParseFieldRanges(t *testing.T) {
+	ranges, err := ParseFieldRanges("1,2..,..3,2..4,-1,-2..")
+	if !assert.NoError(t, err, "ParseFieldRanges should succeed") {
+		return
+	}
+	if !assert.Equal(t, []FieldRange{{1, 1}, {2, 0}, {0, 3}, {2, 4}, {-1, -1}, {-2, 0}}, ranges, "ranges are parsed as expected") {
+		return
+	}
+
+	for _, spec := range []string{"", "0", "a", "1,,2", "1..x"} {
+		if _, err := ParseFieldRanges(spec); !assert.Error(t, err, "ParseFieldRanges should fail for '%s'", spec) {
+			return
+		}
+	}
+}
+
+func TestFieldScope(t *testing.T) {
+	splitter, _ := NewFieldSplitter("")
+
+	testValues := []struct {
+		name    string
+		nth     string
+		input   string
+		display string
+		matches [][]int
+		mapped  [][]int
+	}{
+		{
+			name:    "Open range",
+			nth:     "2..",
+			input:   "1234 root  /usr/bin/foo",
+			display: "root  /usr/bin/foo",
+			matches: [][]int{{0, 4}},
+			mapped:  [][]int{{5, 9}},
+		},
+		{
+			name:    "Disjoint fields",
+			nth:     "1,-1",
+			input:   "foo bar baz",
+			display: "foo baz",
+			// A match across the space that joins the fields is split
+			matches: [][]int{{2, 5}},
+			mapped:  [][]int{{2, 3}, {8, 9}},
+		},
+		{
+			name:    "Missing fields",
+			nth:     "3",
+			input:   "foo bar",
+			display: "",
+		},
+	}
+
+	for i, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			ranges, err := ParseFieldRanges(v.nth)
+			if !assert.NoError(t, err, "ParseFieldRanges should succeed") {
+				return
+			}
+
+			raw := NewRaw(uint64(i), v.input, false)
+			sl := NewFieldScope(splitter, ranges).Apply(raw)
+			if !assert.Equal(t, v.display, sl.DisplayString(), "DisplayString contains the selected fields") {
+				return
+			}
+			if !assert.Equal(t, v.input, sl.Output(), "Output is the original line") {
+				return
+			}
+			if v.matches == nil {
+				return
+			}
+
+			ml, ok := Unscope(NewMatched(sl, v.matches)).(*Matched)
+			if !assert.True(t, ok, "Unscope returns a Matched") {
+				return
+			}
+			if !assert.Equal(t, raw, ml.Line, "Unscope returns the original line") {
+				return
+			}
+			if !assert.Equal(t, v.mapped, ml.Indices(), "indices are mapped to the original line") {
+				return
+			}
+		})
+	}
+}
+
+func TestFielded(t *testing.T) {
+	splitter, err := NewFieldSplitter("")
+	if !assert.NoError(t, err, "NewFieldSplitter should succeed") {
+		return
+	}
+
+	scope := func(spec string) *FieldScope {
+		if spec == "" {
+			return nil
+		}
+		ranges, err := ParseFieldRanges(spec)
+		if err != nil {
+			t.Fatalf("ParseFieldRanges(%q) failed: %s", spec, err)
+		}
+		return NewFieldScope(splitter, ranges)
+	}
+
+	testValues := []struct {
+		name    string
+		input   string
+		sep     bool
+		display string
+		output  string
+		shown   string
+		printed string
+	}{
+		{
+			name:    "Display only",
+			input:   "1234 root /usr/bin/foo",
+			display: "2..",
+			shown:   "root /usr/bin/foo",
+			printed: "1234 root /usr/bin/foo",
+		},
+		{
+			name:    "Display and output",
+			input:   "1234 root /usr/bin/foo",
+			display: "-1",
+			output:  "1",
+			shown:   "/usr/bin/foo",
+			printed: "1234",
+		},
+		{
+			name:    "With null separator",
+			input:   "foo bar\x001 2",
+			sep:     true,
+			display: "2",
+			output:  "-1",
+			shown:   "bar",
+			printed: "2",
+		},
+	}
+
+	for i, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			fl := NewFielded(uint64(i), v.input, v.sep, scope(v.display), scope(v.output))
+			if !assert.Equal(t, v.shown, fl.DisplayString(), "DisplayString contains the displayed fields") {
+				return
+			}
+			if !assert.Equal(t, v.printed, fl.Output(), "Output contains the output fields") {
+				return
+			}
+			if !assert.Equal(t, v.input, fl.Buffer(), "Buffer is the original line") {
+				return
+			}
+		})
+	}
+}
@@ -1,6 +1,12 @@
 package line
 
-import "github.com/google/btree"
+import (
+	"regexp"
+	"text/template"
+
+	"github.com/google/btree"
+	"github.com/nsf/termbox-go"
+)
 
 // IDGenerator defines an interface for things that generate
 // unique IDs for lines used within peco.
@@ -35,6 +41,12 @@ type Line interface {
 	SetDirty(bool)
 }
 
+// MatchStringer is implemented by lines that are matched against some
+// text other than their DisplayString
+type MatchStringer interface {
+	MatchString() string
+}
+
 // Raw is the input line as sent to peco, before filtering and what not.
 type Raw struct {
 	id            uint64
@@ -44,10 +56,129 @@ type Raw struct {
 	dirty         bool
 }
 
+// StyleSpan is a part of the DisplayString of a line that is styled
+// by the ANSI escape sequences in the input. Fg and Bg are termbox
+// attributes, and their colors are ColorDefault when the sequences did
+// not set them
+type StyleSpan struct {
+	Start int
+	End   int
+	Fg    termbox.Attribute
+	Bg    termbox.Attribute
+}
+
+// Styled is implemented by lines that may have StyleSpans
+type Styled interface {
+	Spans() []StyleSpan
+}
+
+// ANSI is a line whose text contains ANSI escape sequences. They are
+// removed from the DisplayString, and the colors and attributes that
+// they set are kept as StyleSpans
+type ANSI struct {
+	*Raw
+	display string
+	spans   []StyleSpan
+	output  string
+}
+
+// JSONFormat creates lines from JSON objects, using templates to
+// choose what is displayed, matched against and output
+type JSONFormat struct {
+	display *template.Template
+	match   *template.Template
+	output  *template.Template
+}
+
+// JSON is a line that was read as a JSON object
+type JSON struct {
+	*Raw
+	display string
+	match   string
+	output  string
+}
+
+// These are the formats of tables that TableFormat reads
+const (
+	TableFormatTSV = "tsv" // TableFormatTSV splits lines by tabs
+	TableFormatCSV = "csv" // TableFormatCSV reads lines as CSV, with quoted cells
+)
+
+// TableFormat splits lines into the cells of a table
+type TableFormat struct {
+	format string
+}
+
+// Table is a line that was read as a row of a table. Its DisplayString
+// is its cells joined by tabs
+type Table struct {
+	*Raw
+	display string
+	columns [][]int
+	output  string
+}
+
+// Columnar is implemented by lines that are rows of a table
+type Columnar interface {
+	// Columns returns the byte offsets of the start and the end of
+	// each cell in the DisplayString
+	Columns() [][]int
+}
+
 // Matched contains the indices to the matches
 type Matched struct {
 	Line
 	indices [][]int
 }
 
+// Scored is a Matched that also carries the score that the filter
+// gave to the match. Higher scores are better
+type Scored struct {
+	*Matched
+	score int
+}
+
+// Fielded is a line that only displays some of the fields of its
+// text. Its output is either the entire text, or some other fields
+type Fielded struct {
+	*Raw
+	display string
+	output  string
+}
+
+// FieldSplitter splits lines into fields, either by a regular
+// expression that matches the delimiter, or by white space
+type FieldSplitter struct {
+	delim *regexp.Regexp
+}
+
+// FieldRange is a range of fields. Fields are numbered from 1, and
+// negative numbers count from the last field (-1 is the last field).
+// Zero means that the range is open on that side
+type FieldRange struct {
+	Start int
+	End   int
+}
+
+// FieldScope selects some of the fields in a line
+type FieldScope struct {
+	ranges   []FieldRange
+	splitter *FieldSplitter
+}
+
+// Scoped is a line whose DisplayString only contains the fields
+// selected by a FieldScope. It is used to restrict matching to those
+// fields. Use Unscope to get back to the original line
+type Scoped struct {
+	Line
+	display string
+	pieces  []scopedPiece
+}
 
+// scopedPiece maps a part of the DisplayString of a Scoped line to
+// the original line
+type scopedPiece struct {
+	at     int // where the piece starts in the scoped string
+	offset int // where the piece starts in the original string
+	length int
+}
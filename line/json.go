@@ -0,0 +1,172 @@
+package line
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// jsonTemplateFuncs are the functions that the templates can use, in
+// addition to the ones that text/template provides
+var jsonTemplateFuncs = template.FuncMap{
+	// json formats a value as JSON, such as a nested object
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// text formats a value as the template would, except for the
+	// members that the object does not have, and nulls, which are
+	// empty text rather than text/template's "<no value>". The actions
+	// of the templates are rewritten to call it by printEmpty
+	"text": func(v interface{}) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	},
+}
+
+// NewJSONFormat creates a new JSONFormat from the templates (in the
+// syntax of text/template) for the text that is displayed, matched
+// against and output. The object is the dot in the templates, so that
+// "{{.name}}" is its "name" member. An empty template for the display
+// or the output selects the original line, and an empty template for
+// matching selects the displayed text
+func NewJSONFormat(display, match, output string) (*JSONFormat, error) {
+	jf := &JSONFormat{}
+	templates := []struct {
+		name string
+		text string
+		t    **template.Template
+	}{
+		{"display", display, &jf.display},
+		{"match", match, &jf.match},
+		{"output", output, &jf.output},
+	}
+	for _, v := range templates {
+		if v.text == "" {
+			continue
+		}
+		t, err := template.New(v.name).Funcs(jsonTemplateFuncs).Parse(v.text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s template", v.name)
+		}
+		for _, tt := range t.Templates() {
+			if tt.Tree != nil {
+				printEmpty(tt.Tree, tt.Tree.Root)
+			}
+		}
+		*v.t = t
+	}
+	return jf, nil
+}
+
+// printEmpty rewrites the actions in list that print a value, such as
+// "{{.name}}", to pass the value on to the "text" function, as in
+// "{{.name | text}}"
+func printEmpty(tree *parse.Tree, list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+
+	for _, n := range list.Nodes {
+		switch n := n.(type) {
+		case *parse.ActionNode:
+			// Actions that declare variables do not print anything
+			if len(n.Pipe.Decl) > 0 {
+				continue
+			}
+			ident := parse.NewIdentifier("text").SetTree(tree).SetPos(n.Pos)
+			n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Pos:      n.Pos,
+				Args:     []parse.Node{ident},
+			})
+		case *parse.IfNode:
+			printEmpty(tree, n.List)
+			printEmpty(tree, n.ElseList)
+		case *parse.RangeNode:
+			printEmpty(tree, n.List)
+			printEmpty(tree, n.ElseList)
+		case *parse.WithNode:
+			printEmpty(tree, n.List)
+			printEmpty(tree, n.ElseList)
+		}
+	}
+}
+
+// NewLine creates a new JSON line from the text v. If v is not a JSON
+// object, or the templates fail, the line is displayed and output as
+// it is. The display and output scopes select the fields of the text
+// that the templates produce, like they do for NewFielded
+func (jf *JSONFormat) NewLine(id uint64, v string, display, output *FieldScope) *JSON {
+	jl := &JSON{
+		Raw:     NewRaw(id, v, false),
+		display: v,
+		output:  v,
+	}
+
+	matched := false
+	var obj map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(v))
+	// Keep numbers as they were written, instead of as floats
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err == nil {
+		if s, err := executeJSONTemplate(jf.display, obj); err == nil {
+			jl.display = s
+		}
+		if s, err := executeJSONTemplate(jf.output, obj); err == nil {
+			jl.output = s
+		}
+		if s, err := executeJSONTemplate(jf.match, obj); err == nil {
+			jl.match = s
+			matched = true
+		}
+	}
+
+	if display != nil {
+		jl.display = display.Extract(jl.display)
+	}
+	if output != nil {
+		jl.output = output.Extract(jl.output)
+	}
+	if !matched {
+		jl.match = jl.display
+	}
+	return jl
+}
+
+// executeJSONTemplate returns the text that t produces for obj. It
+// fails if there is no template
+func executeJSONTemplate(t *template.Template, obj map[string]interface{}) (string, error) {
+	if t == nil {
+		return "", errors.New("no template")
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, obj); err != nil {
+		return "", errors.Wrapf(err, "failed to execute %s template", t.Name())
+	}
+	return buf.String(), nil
+}
+
+// DisplayString returns the text from the display template
+func (jl JSON) DisplayString() string {
+	return jl.display
+}
+
+// MatchString returns the text from the match template, or the
+// DisplayString if there is none
+func (jl JSON) MatchString() string {
+	return jl.match
+}
+
+// Output returns the text from the output template
+func (jl JSON) Output() string {
+	return jl.output
+}
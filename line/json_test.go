@@ -0,0 +1,98 @@
+package line
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONFormat(t *testing.T) {
+	testValues := []struct {
+		name    string
+		display string
+		match   string
+		output  string
+		input   string
+		expect  []string // display, match and output
+	}{
+		{
+			name:    "Templates",
+			display: "{{.name}} {{.path}}",
+			match:   "{{.name}}",
+			output:  "{{.id}}",
+			input:   `{"id": 12345678901, "name": "foo", "path": "/tmp"}`,
+			expect:  []string{"foo /tmp", "foo", "12345678901"},
+		},
+		{
+			name:   "Default templates",
+			input:  `{"name": "foo"}`,
+			expect: []string{`{"name": "foo"}`, `{"name": "foo"}`, `{"name": "foo"}`},
+		},
+		{
+			name:    "Missing members",
+			display: "{{.name}}:{{.missing}}",
+			output:  "{{.tags | json}}",
+			input:   `{"name": "foo", "tags": ["a", "b"]}`,
+			expect:  []string{"foo:", "foo:", `["a","b"]`},
+		},
+		{
+			name:    "Null members and literal text",
+			display: "{{.name}}:{{.none}}{{if .name}}!{{.missing}}{{end}}",
+			match:   `{{with .none}}x{{else}}{{.note | printf "%s"}}{{end}}`,
+			output:  "{{$n := .name}}{{$n}}",
+			input:   `{"name": "<no value>", "none": null, "note": "n/a"}`,
+			expect:  []string{"<no value>:!", "n/a", "<no value>"},
+		},
+		{
+			name:    "Not a JSON object",
+			display: "{{.name}}",
+			output:  "{{.id}}",
+			input:   "foo bar",
+			expect:  []string{"foo bar", "foo bar", "foo bar"},
+		},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			jf, err := NewJSONFormat(v.display, v.match, v.output)
+			if !assert.NoError(t, err, "NewJSONFormat should succeed") {
+				return
+			}
+			jl := jf.NewLine(0, v.input, nil, nil)
+			if !assert.Equal(t, v.expect, []string{jl.DisplayString(), jl.MatchString(), jl.Output()}, "texts should match") {
+				return
+			}
+			if !assert.Equal(t, v.input, jl.Buffer(), "buffer should be the original line") {
+				return
+			}
+		})
+	}
+
+	_, err := NewJSONFormat("{{.name", "", "")
+	if !assert.Error(t, err, "NewJSONFormat should fail for invalid templates") {
+		return
+	}
+}
+
+func TestScopeText(t *testing.T) {
+	jf, err := NewJSONFormat("{{.name}} {{.path}}", "{{.path}}", "")
+	if !assert.NoError(t, err, "NewJSONFormat should succeed") {
+		return
+	}
+	jl := jf.NewLine(0, `{"name": "foo", "path": "/tmp"}`, nil, nil)
+
+	sl := ScopeText(jl, jl.MatchString())
+	if !assert.Equal(t, "/tmp", sl.DisplayString(), "scoped line should contain the text") {
+		return
+	}
+	// "/tmp" appears in the displayed text, but it could have come from
+	// any of the members
+	if !assert.Equal(t, jl, Unscope(NewMatched(sl, [][]int{{1, 3}})), "matches in other text should be dropped") {
+		return
+	}
+
+	sl = ScopeText(jl, jl.DisplayString())
+	if !assert.Equal(t, [][]int{{5, 7}}, Unscope(NewMatched(sl, [][]int{{5, 7}})).(*Matched).Indices(), "matches in the displayed text should be kept") {
+		return
+	}
+}
@@ -10,3 +10,29 @@ func (ml Matched) Indices() [][]int {
 	return ml.indices
 }
 
+// Spans returns the styled parts of the original line, if any
+func (ml Matched) Spans() []StyleSpan {
+	if sl, ok := ml.Line.(Styled); ok {
+		return sl.Spans()
+	}
+	return nil
+}
+
+// Columns returns the cells of the original line, if it is a row of
+// a table
+func (ml Matched) Columns() [][]int {
+	if cl, ok := ml.Line.(Columnar); ok {
+		return cl.Columns()
+	}
+	return nil
+}
+
+// NewScored creates a new Scored
+func NewScored(rl Line, matches [][]int, score int) *Scored {
+	return &Scored{NewMatched(rl, matches), score}
+}
+
+// Score returns the score of the match
+func (sl Scored) Score() int {
+	return sl.score
+}
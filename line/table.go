@@ -0,0 +1,110 @@
+package line
+
+import (
+	"encoding/csv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// cellReplacer replaces the characters that would break the rows of a
+// table, such as the tabs and the line breaks in quoted CSV cells
+var cellReplacer = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+// NewTableFormat creates a TableFormat that reads lines in the given
+// format, TableFormatTSV or TableFormatCSV
+func NewTableFormat(format string) (*TableFormat, error) {
+	switch format {
+	case TableFormatTSV, TableFormatCSV:
+		return &TableFormat{format: format}, nil
+	}
+	return nil, errors.Errorf("unknown table format '%s'", format)
+}
+
+// Split returns the cells of s. CSV cells may be quoted, and a line
+// that is not valid CSV is a single cell
+func (tf *TableFormat) Split(s string) []string {
+	if tf.format == TableFormatTSV {
+		return strings.Split(s, "\t")
+	}
+
+	r := csv.NewReader(strings.NewReader(s))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	cells, err := r.Read()
+	if err != nil {
+		return []string{s}
+	}
+	return cells
+}
+
+// Join returns the cells as a line in the format of the table,
+// quoting CSV cells where necessary
+func (tf *TableFormat) Join(cells []string) string {
+	if tf.format == TableFormatTSV {
+		return strings.Join(cells, "\t")
+	}
+
+	var buf strings.Builder
+	w := csv.NewWriter(&buf)
+	w.Write(cells)
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// NewLine creates a new Table line from the text v. If display is
+// non-nil, only the columns that it selects are displayed (and matched
+// against). If output is non-nil, only the columns that it selects are
+// output, in the format of the table, otherwise the entire text is.
+// The enableSep flag works in the same way as it does for NewRaw
+func (tf *TableFormat) NewLine(id uint64, v string, enableSep bool, display, output *FieldScope) *Table {
+	rl := NewRaw(id, v, enableSep)
+	tl := &Table{
+		Raw:    rl,
+		output: rl.Output(),
+	}
+
+	cells := tf.Split(rl.DisplayString())
+	if display != nil {
+		cells = selectCells(cells, display)
+	}
+
+	var buf strings.Builder
+	for i, c := range cells {
+		if i > 0 {
+			buf.WriteByte('\t')
+		}
+		start := buf.Len()
+		buf.WriteString(cellReplacer.Replace(c))
+		tl.columns = append(tl.columns, []int{start, buf.Len()})
+	}
+	tl.display = buf.String()
+
+	if output != nil {
+		tl.output = tf.Join(selectCells(tf.Split(tl.output), output))
+	}
+	return tl
+}
+
+func selectCells(cells []string, scope *FieldScope) []string {
+	var selected []string
+	for _, i := range scope.Fields(len(cells)) {
+		selected = append(selected, cells[i])
+	}
+	return selected
+}
+
+// DisplayString returns the cells of the row, joined by tabs
+func (tl Table) DisplayString() string {
+	return tl.display
+}
+
+// Columns returns the byte offsets of the cells in the DisplayString
+func (tl Table) Columns() [][]int {
+	return tl.columns
+}
+
+// Output returns the selected columns to output, or the entire text
+func (tl Table) Output() string {
+	return tl.output
+}
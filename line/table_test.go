@@ -0,0 +1,90 @@
+package line
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableFormat(t *testing.T) {
+	_, err := NewTableFormat("xml")
+	if !assert.Error(t, err, "unknown formats should be rejected") {
+		return
+	}
+
+	testValues := []struct {
+		name   string
+		format string
+		input  string
+		cells  []string
+	}{
+		{"TSV", TableFormatTSV, "NAME\tREADY\tSTATUS", []string{"NAME", "READY", "STATUS"}},
+		{"TSV empty cells", TableFormatTSV, "a\t\tc", []string{"a", "", "c"}},
+		{"CSV", TableFormatCSV, "foo,bar,baz", []string{"foo", "bar", "baz"}},
+		{"CSV quoted", TableFormatCSV, `"foo, bar","say ""hi""",baz`, []string{"foo, bar", `say "hi"`, "baz"}},
+		{"CSV unterminated quote", TableFormatCSV, `foo,"bar`, []string{"foo", "bar"}},
+		{"CSV empty line", TableFormatCSV, "", []string{""}},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			tf, err := NewTableFormat(v.format)
+			if !assert.NoError(t, err, "NewTableFormat should succeed") {
+				return
+			}
+			if !assert.Equal(t, v.cells, tf.Split(v.input), "cells should match") {
+				return
+			}
+		})
+	}
+
+	tf, _ := NewTableFormat(TableFormatCSV)
+	if !assert.Equal(t, `"foo, bar",baz`, tf.Join([]string{"foo, bar", "baz"}), "cells should be quoted") {
+		return
+	}
+}
+
+func TestTable(t *testing.T) {
+	tf, _ := NewTableFormat(TableFormatCSV)
+
+	tl := tf.NewLine(0, `web-1,"Running, ready",3`, false, nil, nil)
+	if !assert.Equal(t, "web-1\tRunning, ready\t3", tl.DisplayString(), "cells should be joined by tabs") {
+		return
+	}
+	if !assert.Equal(t, [][]int{{0, 5}, {6, 20}, {21, 22}}, tl.Columns(), "columns should match") {
+		return
+	}
+	if !assert.Equal(t, `web-1,"Running, ready",3`, tl.Output(), "output should be the entire line") {
+		return
+	}
+
+	ranges, _ := ParseFieldRanges("3,1")
+	scope := NewFieldScope(&FieldSplitter{}, ranges)
+	tl = tf.NewLine(0, `web-1,"Running, ready",3`, false, scope, scope)
+	if !assert.Equal(t, "3\tweb-1", tl.DisplayString(), "selected columns should be displayed") {
+		return
+	}
+	if !assert.Equal(t, "3,web-1", tl.Output(), "selected columns should be output as CSV") {
+		return
+	}
+
+	t.Run("Scope", func(t *testing.T) {
+		// Columns are the fields, whatever the splitter says
+		ranges, _ := ParseFieldRanges("2")
+		scope := NewFieldScope(&FieldSplitter{}, ranges)
+		tl := tf.NewLine(0, `web-1,"Running, ready",3`, false, nil, nil)
+		sl := scope.Apply(tl)
+		if !assert.Equal(t, "Running, ready", sl.DisplayString(), "the second column should be selected") {
+			return
+		}
+
+		ml := NewMatched(sl, [][]int{{9, 14}})
+		ul := Unscope(ml)
+		if !assert.Equal(t, [][]int{{15, 20}}, ul.(*Matched).Indices(), "indices should be mapped to the row") {
+			return
+		}
+		if !assert.Equal(t, tl.Columns(), ul.(Columnar).Columns(), "matched rows should have the columns") {
+			return
+		}
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"reflect"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 	"unicode/utf8"
@@ -221,13 +222,34 @@ func (p *Peco) SingleKeyJumpIndex(ch rune) (uint, bool) {
 }
 
 func (p *Peco) Source() pipeline.Source {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	return p.source
 }
 
+// Header returns the header lines, which are the text given by
+// --header followed by the header lines of the input
+func (p *Peco) Header() []line.Line {
+	src, ok := p.Source().(*Source)
+	if !ok || src == nil {
+		return p.header
+	}
+	if h := src.Header(); len(h) > 0 {
+		return append(p.header[:len(p.header):len(p.header)], h...)
+	}
+	return p.header
+}
+
 func (p *Peco) Filters() *filter.Set {
 	return &p.filters
 }
 
+// FieldScope returns the fields of the lines that the filters should
+// match against, or nil if they should match against entire lines
+func (p *Peco) FieldScope() *line.FieldScope {
+	return p.fieldScope
+}
+
 func (p *Peco) Query() *Query {
 	return &p.query
 }
@@ -269,7 +291,9 @@ func (p *Peco) Setup() (err error) {
 		defer g.End()
 	}
 
-	if err := p.config.Init(); err != nil {
+	if p.customConfig != nil {
+		p.config = *p.customConfig
+	} else if err := p.config.Init(); err != nil {
 		return errors.Wrap(err, "failed to initialize config")
 	}
 
@@ -324,6 +348,7 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 	if err := p.Setup(); err != nil {
 		return errors.Wrap(err, "failed to setup peco")
 	}
+	defer p.filters.Close()
 
 	var _cancelOnce sync.Once
 	var _cancel func()
@@ -349,6 +374,23 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 
 	go sigH.Loop(ctx, cancel)
 
+	if p.filterMode {
+		// There is no screen to display anything on, so we read the
+		// whole source, print the results, and bail out right away
+		defer cancel()
+		go p.discardMessages(ctx)
+
+		src, err := p.SetupSource(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to setup input source")
+		}
+		defer p.stopSourceCmd()
+		p.source = src
+		readyOnce.Do(func() { close(p.readyCh) })
+
+		return p.printFilterResults(ctx)
+	}
+
 	// SetupSource is done AFTER other components are ready, otherwise
 	// we can't draw onto the screen while we are reading a really big
 	// buffer.
@@ -357,14 +399,27 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 	if err != nil {
 		return errors.Wrap(err, "failed to setup input source")
 	}
+	defer p.stopSourceCmd()
 	p.source = src
+	go p.watchSource(src)
+
+	if p.listenPath != "" {
+		ln, err := p.listenRemote(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to start remote control")
+		}
+		defer ln.Close()
+	}
 
 	go func() {
-		<-p.source.Ready()
+		<-src.Ready()
 		// screen.Init must be called within Run() because we
 		// want to make sure to call screen.Close() after getting
 		// out of Run()
-		p.screen.Init(&p.config)
+		if err := p.screen.Init(&p.config); err != nil {
+			p.Exit(errors.Wrap(err, "failed to initialize screen"))
+			return
+		}
 		go NewInput(p, p.Keymap(), p.screen.PollEvent(ctx, &p.config)).Loop(ctx, cancel)
 		go NewView(p).Loop(ctx, cancel)
 		go NewFilter(p).Loop(ctx, cancel)
@@ -388,7 +443,7 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 			// source.Ready(), because Ready returns as soon as we get
 			// a line, where as SetupDone waits until we're completely
 			// done reading the input
-			<-p.source.SetupDone()
+			<-src.SetupDone()
 			p.selectOneAndExitIfPossible()
 		}()
 	}
@@ -402,9 +457,11 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 		p.Caret().SetPos(utf8.RuneCountInString(q))
 	}
 
-	if p.Query().Len() > 0 {
+	// If the source command was run with the initial query, the
+	// results are already there
+	if p.Query().Len() > 0 && !p.queryDrivenSource() {
 		go func() {
-			<-p.source.Ready()
+			<-src.Ready()
 
 			// iff p.selectOneAndExit is true, we should check after exec query is run
 			// if we only have one item
@@ -458,6 +515,24 @@ func (p *Peco) SetupSource(ctx context.Context) (s *Source, err error) {
 		defer g.End()
 	}
 
+	if p.sourceCmd != "" {
+		query := p.initialQuery
+		if p.filterMode {
+			query = p.filterQuery
+		}
+		src, cancel, err := p.startSourceCmd(ctx, query)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to start source command")
+		}
+		p.mutex.Lock()
+		p.sourceCancel = cancel
+		p.mutex.Unlock()
+
+		// Block until we receive something from the command
+		<-src.Ready()
+		return src, nil
+	}
+
 	var in io.Reader
 	var filename string
 	var isInfinite bool
@@ -472,6 +547,12 @@ func (p *Peco) SetupSource(ctx context.Context) (s *Source, err error) {
 		}
 		in = f
 		filename = p.args[1]
+		// In filter mode, the results are printed after the file has
+		// been read to the end, so it is never followed
+		if p.follow && !p.filterMode {
+			in = newFollowReader(ctx, f, p.args[1], followInterval)
+			isInfinite = true
+		}
 	case !util.IsTty(p.Stdin):
 		if pdebug.Enabled {
 			pdebug.Printf("Using p.Stdin as input")
@@ -488,6 +569,11 @@ func (p *Peco) SetupSource(ctx context.Context) (s *Source, err error) {
 	}
 
 	src := NewSource(filename, in, isInfinite, p.idgen, p.bufferSize, p.enableSep)
+	src.SetFields(p.displayFields, p.outputFields)
+	src.SetANSI(p.ansi, p.keepANSI)
+	src.SetJSON(p.jsonFormat)
+	src.SetTable(p.tableFormat)
+	src.SetHeaderLines(p.headerLines)
 
 	// Block until we receive something from `in`
 	if pdebug.Enabled {
@@ -500,6 +586,153 @@ func (p *Peco) SetupSource(ctx context.Context) (s *Source, err error) {
 	return src, nil
 }
 
+// startSourceCmd starts the command given to --source-cmd, with "{q}"
+// replaced by query, and creates a Source that reads its output. The
+// returned function stops the command
+func (p *Peco) startSourceCmd(ctx context.Context, query string) (*Source, func(), error) {
+	command := strings.Replace(p.sourceCmd, "{q}", util.ShellQuote(query), -1)
+	if pdebug.Enabled {
+		pdebug.Printf("Starting source command %s", command)
+	}
+
+	cmd := util.Shell(command)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get stdout pipe")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to start command")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	src := NewSource(p.sourceCmd, stdout, true, p.idgen, p.bufferSize, p.enableSep)
+	src.SetFields(p.displayFields, p.outputFields)
+	src.SetANSI(p.ansi, p.keepANSI)
+	src.SetJSON(p.jsonFormat)
+	src.SetTable(p.tableFormat)
+	src.SetHeaderLines(p.headerLines)
+	go src.Setup(ctx, p)
+	go func() {
+		<-src.SetupDone()
+		if ctx.Err() != nil {
+			// Stopped before we read everything
+			cmd.Process.Kill()
+		}
+		cmd.Wait()
+	}()
+	return src, cancel, nil
+}
+
+// queryDrivenSource returns true if the source is reloaded each time
+// the query changes, instead of being filtered
+func (p *Peco) queryDrivenSource() bool {
+	return strings.Contains(p.sourceCmd, "{q}")
+}
+
+// ReloadSource executes the command given to --source-cmd again, and
+// replaces the current source with its output. If the command depends
+// on the query, query is substituted into it. Otherwise, the current
+// query is run against the new source
+func (p *Peco) ReloadSource(ctx context.Context, query string) error {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.ReloadSource (query=%s)", query)
+		defer g.End()
+	}
+
+	if p.sourceCmd == "" {
+		return errors.New("there is no source command to reload")
+	}
+
+	src, cancel, err := p.startSourceCmd(ctx, query)
+	if err != nil {
+		return errors.Wrap(err, "failed to start source command")
+	}
+
+	p.mutex.Lock()
+	prevCancel := p.sourceCancel
+	p.source = src
+	p.sourceCancel = cancel
+	p.mutex.Unlock()
+
+	if prevCancel != nil {
+		prevCancel()
+	}
+	go p.watchSource(src)
+
+	if q := p.Query().String(); q != "" && !p.queryDrivenSource() {
+		p.sendQuery(ctx, q, nil)
+		return nil
+	}
+	p.ResetCurrentLineBuffer()
+	return nil
+}
+
+// watchSource waits until src has been read to the end, and emits
+// EventLoad if it is still the current source. If the lines of the
+// source are displayed as they are, this also concludes the result of
+// the query, so the match events are emitted as well
+func (p *Peco) watchSource(src *Source) {
+	<-src.SetupDone()
+
+	p.mutex.Lock()
+	current := p.source == src
+	p.mutex.Unlock()
+	if !current {
+		return
+	}
+
+	ctx := context.Background()
+	p.emitEvent(ctx, EventLoad)
+	if p.Query().Len() <= 0 || p.queryDrivenSource() {
+		p.emitMatchEvent(ctx, src.Size())
+	}
+}
+
+// emitEvent sends the event through the hub, if there are actions
+// bound to it
+func (p *Peco) emitEvent(ctx context.Context, name string) {
+	if !p.Keymap().hasEventActions(name) {
+		return
+	}
+
+	if pdebug.Enabled {
+		pdebug.Printf("Emitting event %s", name)
+	}
+	p.Hub().SendEvent(ctx, name)
+}
+
+// emitMatchEvent emits the event for a query that finished with n
+// matching lines, if there is one
+func (p *Peco) emitMatchEvent(ctx context.Context, n int) {
+	switch n {
+	case 0:
+		p.emitEvent(ctx, EventZeroMatch)
+	case 1:
+		p.emitEvent(ctx, EventOneMatch)
+	}
+}
+
+// emitSourceMatchEvent emits the match event for the lines of the
+// source, when they are displayed as they are. If the source is still
+// being read, watchSource emits it once it is done instead
+func (p *Peco) emitSourceMatchEvent(ctx context.Context) {
+	if src, ok := p.Source().(*Source); ok && isSetupDone(src) {
+		p.emitMatchEvent(ctx, src.Size())
+	}
+}
+
+// stopSourceCmd stops the command that produces the source, if any
+func (p *Peco) stopSourceCmd() {
+	p.mutex.Lock()
+	cancel := p.sourceCancel
+	p.sourceCancel = nil
+	p.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
 func readConfig(cfg *Config, filename string) error {
 	if filename != "" {
 		if err := cfg.ReadFilename(filename); err != nil {
@@ -560,6 +793,18 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 	}
 	p.selectOneAndExit = opts.OptSelect1
 	p.printQuery = opts.OptPrintQuery
+	p.sourceCmd = opts.OptSourceCmd
+	p.ansi = opts.OptANSI
+	p.keepANSI = opts.OptKeepANSI
+	p.follow = opts.OptFollow
+	p.listenPath = opts.OptListen
+	if v := p.config.QueryExecutionDelay; v > 0 {
+		p.queryExecDelay = time.Duration(v) * time.Millisecond
+	}
+	if v := opts.OptFilter; v != nil {
+		p.filterMode = true
+		p.filterQuery = *v
+	}
 	p.initialQuery = opts.OptQuery
 	p.initialFilter = opts.OptInitialFilter
 	if len(p.initialFilter) <= 0 {
@@ -569,11 +814,40 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 		p.initialFilter = opts.OptInitialMatcher
 	}
 	p.fuzzyLongestSort = p.config.FuzzyLongestSort
+	p.fuzzyScoreSort = p.config.FuzzyScoreSort
+
+	if err := p.populateFieldScope(opts); err != nil {
+		return errors.Wrap(err, "failed to populate field scope")
+	}
+
+	if err := p.populatePreview(opts); err != nil {
+		return errors.Wrap(err, "failed to populate preview")
+	}
+
+	if err := p.populateJSONFormat(opts); err != nil {
+		return errors.Wrap(err, "failed to populate JSON format")
+	}
+
+	if v := opts.OptTable; v != "" {
+		tf, err := line.NewTableFormat(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid --table")
+		}
+		p.tableFormat = tf
+	}
+
+	if err := p.populateHeader(opts); err != nil {
+		return errors.Wrap(err, "failed to populate header")
+	}
 
 	if err := p.populateFilters(); err != nil {
 		return errors.Wrap(err, "failed to populate filters")
 	}
 
+	if err := p.populateScreen(opts); err != nil {
+		return errors.Wrap(err, "failed to populate screen")
+	}
+
 	if err := p.populateKeymap(); err != nil {
 		return errors.Wrap(err, "failed to populate keymap")
 	}
@@ -593,6 +867,136 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 	return nil
 }
 
+func (p *Peco) populateFieldScope(opts CLIOptions) error {
+	delim := p.config.Delimiter
+	if v := opts.OptDelimiter; v != "" {
+		delim = v
+	}
+	nth := p.config.Nth
+	if v := opts.OptNth; v != "" {
+		nth = v
+	}
+	withNth := p.config.WithNth
+	if v := opts.OptWithNth; v != "" {
+		withNth = v
+	}
+	outputNth := p.config.OutputNth
+	if v := opts.OptOutputNth; v != "" {
+		outputNth = v
+	}
+
+	if nth == "" && withNth == "" && outputNth == "" {
+		return nil
+	}
+
+	splitter, err := line.NewFieldSplitter(delim)
+	if err != nil {
+		return errors.Wrap(err, "invalid delimiter")
+	}
+
+	scopes := []struct {
+		name  string
+		spec  string
+		scope **line.FieldScope
+	}{
+		{"--nth", nth, &p.fieldScope},
+		{"--with-nth", withNth, &p.displayFields},
+		{"--output-nth", outputNth, &p.outputFields},
+	}
+	for _, s := range scopes {
+		if s.spec == "" {
+			continue
+		}
+		ranges, err := line.ParseFieldRanges(s.spec)
+		if err != nil {
+			return errors.Wrapf(err, "invalid field ranges for %s", s.name)
+		}
+		*s.scope = line.NewFieldScope(splitter, ranges)
+	}
+	return nil
+}
+
+// populateJSONFormat creates the format for JSON input, if it was
+// asked for. The templates given on the command line take precedence
+// over the ones in the config file
+func (p *Peco) populateJSONFormat(opts CLIOptions) error {
+	if !opts.OptJSON && opts.OptJSONDisplay == "" && opts.OptJSONMatch == "" && opts.OptJSONOutput == "" {
+		return nil
+	}
+
+	cfg := p.config.JSON
+	if v := opts.OptJSONDisplay; v != "" {
+		cfg.Display = v
+	}
+	if v := opts.OptJSONMatch; v != "" {
+		cfg.Match = v
+	}
+	if v := opts.OptJSONOutput; v != "" {
+		cfg.Output = v
+	}
+
+	jf, err := line.NewJSONFormat(cfg.Display, cfg.Match, cfg.Output)
+	if err != nil {
+		return errors.Wrap(err, "invalid JSON template")
+	}
+	p.jsonFormat = jf
+	return nil
+}
+
+// populateHeader sets up the header lines. The first line of a table
+// is always its header
+func (p *Peco) populateHeader(opts CLIOptions) error {
+	if opts.OptHeaderLines < 0 {
+		return errors.Errorf("invalid --header-lines %d", opts.OptHeaderLines)
+	}
+	p.headerLines = opts.OptHeaderLines
+	if p.tableFormat != nil && p.headerLines < 1 {
+		p.headerLines = 1
+	}
+
+	p.header = nil
+	if opts.OptHeader == "" {
+		return nil
+	}
+	for _, v := range strings.Split(opts.OptHeader, "\n") {
+		if tf := p.tableFormat; tf != nil {
+			p.header = append(p.header, tf.NewLine(0, v, false, p.displayFields, nil))
+		} else {
+			p.header = append(p.header, line.NewRaw(0, v, false))
+		}
+	}
+	return nil
+}
+
+func (p *Peco) populatePreview(opts CLIOptions) error {
+	p.preview = p.config.Preview
+	if v := opts.OptPreview; v != "" {
+		p.preview.Command = v
+	}
+	if v := opts.OptPreviewPosition; v != "" {
+		p.preview.Position = v
+	}
+	if v := opts.OptPreviewSize; v != 0 {
+		p.preview.Size = v
+	}
+
+	switch p.preview.Position {
+	case "":
+		p.preview.Position = PreviewPositionRight
+	case PreviewPositionRight, PreviewPositionBottom:
+	default:
+		return errors.Errorf("unknown preview position: '%s'", p.preview.Position)
+	}
+
+	switch v := p.preview.Size; {
+	case v == 0:
+		p.preview.Size = 50
+	case v < 0 || v >= 100:
+		return errors.Errorf("preview size must be between 1 and 99 (was %d)", v)
+	}
+	return nil
+}
+
 func (p *Peco) populateInitialFilter() error {
 	if v := p.initialFilter; len(v) > 0 {
 		if err := p.filters.SetCurrentByName(v); err != nil {
@@ -619,15 +1023,61 @@ func (p *Peco) populateSingleKeyJump() error {
 	return nil
 }
 
+// populateScreen replaces the default termbox screen with the one
+// that was asked for. A screen that was given by the caller is kept
+func (p *Peco) populateScreen(opts CLIOptions) error {
+	if v := opts.OptHeight; v != "" {
+		s, err := NewInlineScreen(v)
+		if err != nil {
+			return errors.Wrap(err, "failed to create inline screen")
+		}
+		if _, ok := p.screen.(*Termbox); ok {
+			p.screen = s
+		}
+		return nil
+	}
+
+	name := opts.OptScreen
+	if name == "" {
+		name = p.config.Screen
+	}
+
+	switch name {
+	case "", ScreenTypeTermbox:
+		return nil
+	case ScreenTypeTcell:
+		if _, ok := p.screen.(*Termbox); ok {
+			p.screen = NewTcell()
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown screen %s", name)
+	}
+}
+
 func (p *Peco) populateFilters() error {
 	p.filters.Add(filter.NewIgnoreCase())
 	p.filters.Add(filter.NewCaseSensitive())
 	p.filters.Add(filter.NewSmartCase())
 	p.filters.Add(filter.NewRegexp())
-	p.filters.Add(filter.NewFuzzy(p.fuzzyLongestSort))
+	if p.fuzzyScoreSort {
+		p.filters.Add(filter.NewScoredFuzzy())
+	} else {
+		p.filters.Add(filter.NewFuzzy(p.fuzzyLongestSort))
+	}
+	p.filters.Add(filter.NewExtended())
 
 	for name, c := range p.config.CustomFilter {
 		f := filter.NewExternalCmd(name, c.Cmd, c.Args, c.BufferThreshold, p.idgen, p.enableSep)
+		if err := f.SetFormat(c.Format); err != nil {
+			return errors.Wrapf(err, "failed to setup CustomFilter '%s'", name)
+		}
+		f.SetPersistent(c.Persistent)
+		f.SetTimeout(time.Duration(c.Timeout) * time.Millisecond)
+		p.filters.Add(f)
+	}
+
+	for _, f := range p.customFilters {
 		p.filters.Add(f)
 	}
 
@@ -637,9 +1087,13 @@ func (p *Peco) populateFilters() error {
 func (p *Peco) populateKeymap() error {
 	// Create a new keymap object
 	k := NewKeymap(p.config.Keymap, p.config.Action)
+	k.custom = p.customActions
 	if err := k.ApplyKeybinding(); err != nil {
 		return errors.Wrap(err, "failed to apply key bindings")
 	}
+	if err := k.ApplyEvents(p.config.Event); err != nil {
+		return errors.Wrap(err, "failed to apply event actions")
+	}
 	p.keymap = k
 	return nil
 }
@@ -667,16 +1121,30 @@ func (p *Peco) SetCurrentLineBuffer(b Buffer) {
 }
 
 func (p *Peco) ResetCurrentLineBuffer() {
-	p.SetCurrentLineBuffer(p.source)
+	p.mutex.Lock()
+	src := p.source
+	p.mutex.Unlock()
+	p.SetCurrentLineBuffer(src)
+}
+
+func (p *Peco) reloadSource(ctx context.Context, q string, nextFunc func()) {
+	if err := p.ReloadSource(ctx, q); err != nil {
+		p.Hub().SendStatusMsg(ctx, err.Error())
+		return
+	}
+	if nextFunc != nil {
+		nextFunc()
+	}
 }
 
 func (p *Peco) sendQuery(ctx context.Context, q string, nextFunc func()) {
+	src := p.Source().(*Source)
 	if pdebug.Enabled {
-		g := pdebug.Marker("sending query to filter goroutine (q=%v, isInfinite=%t)", q, p.source.IsInfinite())
+		g := pdebug.Marker("sending query to filter goroutine (q=%v, isInfinite=%t)", q, src.IsInfinite())
 		defer g.End()
 	}
 
-	if p.source.IsInfinite() {
+	if src.IsInfinite() {
 		// If the source is a stream, we can't do batch mode, and hence
 		// we can't guarantee proper timing. But... okay, we simulate
 		// something like it
@@ -717,14 +1185,31 @@ func (p *Peco) ExecQuery(nextFunc func()) bool {
 		return false
 	}
 
-	// If this is an empty query, reset the display to show
-	// the raw source buffer
 	q := p.Query()
-	if q.Len() <= 0 {
+
+	p.queryExecMutex.Lock()
+	changed := q.String() != p.lastQuery
+	p.lastQuery = q.String()
+	p.queryExecMutex.Unlock()
+	if changed {
+		// Actions may be executing this, so we must not wait for the
+		// event to be received
+		go p.emitEvent(context.Background(), EventChange)
+	}
+
+	// If the source depends on the query, the source is reloaded
+	// instead of being filtered
+	send := p.sendQuery
+	if p.queryDrivenSource() {
+		send = p.reloadSource
+	} else if q.Len() <= 0 {
+		// If this is an empty query, reset the display to show
+		// the raw source buffer
 		if pdebug.Enabled {
 			pdebug.Printf("empty query, reset buffer")
 		}
 		p.ResetCurrentLineBuffer()
+		go p.emitSourceMatchEvent(context.Background())
 
 		hub.Batch(context.Background(), func(ctx context.Context) {
 			hub.SendDraw(ctx, &DrawOptions{DisableCache: true})
@@ -741,7 +1226,7 @@ func (p *Peco) ExecQuery(nextFunc func()) bool {
 			pdebug.Printf("sending query (immediate)")
 		}
 
-		p.sendQuery(context.Background(), q.String(), nextFunc)
+		send(context.Background(), q.String(), nextFunc)
 		return true
 	}
 
@@ -764,7 +1249,7 @@ func (p *Peco) ExecQuery(nextFunc func()) bool {
 		if pdebug.Enabled {
 			pdebug.Printf("delayed query sent")
 		}
-		p.sendQuery(context.Background(), q.String(), nextFunc)
+		send(context.Background(), q.String(), nextFunc)
 
 		if pdebug.Enabled {
 			pdebug.Printf("delayed query executed")
@@ -813,3 +1298,86 @@ func (p *Peco) PrintResults() {
 	}
 	p.Stdout.Write(buf.Bytes())
 }
+
+// discardMessages drains the messages sent through the hub. This is
+// used when there is no screen to handle them, so that the senders
+// do not block forever
+func (p *Peco) discardMessages(ctx context.Context) {
+	h := p.Hub()
+	for {
+		var payload hub.Payload
+		select {
+		case <-ctx.Done():
+			return
+		case payload = <-h.DrawCh():
+		case payload = <-h.EventCh():
+		case payload = <-h.PagingCh():
+		case payload = <-h.QueryCh():
+		case payload = <-h.StatusMsgCh():
+		}
+		payload.Done()
+	}
+}
+
+// printFilterResults runs the current filter against the entire
+// source using the query given to --filter, and prints the matching
+// lines in the same order as they would be displayed
+func (p *Peco) printFilterResults(ctx context.Context) (err error) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.printFilterResults (query=%s)", p.filterQuery).BindError(&err)
+		defer g.End()
+	}
+
+	query := p.filterQuery
+
+	// If the source command was run with the query, it has done the
+	// filtering for us
+	var buf Buffer = p.source
+	if query == "" || p.queryDrivenSource() {
+		select {
+		case <-ctx.Done():
+			return p.Err()
+		case <-p.source.SetupDone():
+		}
+	} else {
+		selectedFilter := p.Filters().Current()
+
+		pl := pipeline.New()
+		pl.SetSource(p.source)
+		fp := newFilterProcessor(selectedFilter, query, p.FieldScope())
+		pl.Add(fp)
+
+		mb := NewMemoryBuffer()
+		if r, ok := selectedFilter.(filter.Ranker); ok {
+			mb.SetRankFunc(r.RankFunc())
+		}
+		pl.SetDestination(mb)
+
+		if err := pl.Run(selectedFilter.NewContext(ctx, query)); err != nil {
+			return errors.Wrap(err, "failed to run filter")
+		}
+		if err := fp.Err(); err != nil {
+			return errors.Wrap(err, "filter failed")
+		}
+		if ctx.Err() != nil {
+			// Interrupted, so the results are incomplete
+			return p.Err()
+		}
+		buf = mb
+	}
+
+	out := bufio.NewWriter(p.Stdout)
+	if p.printQuery {
+		out.WriteString(query)
+		out.WriteByte('\n')
+	}
+	for i := 0; i < buf.Size(); i++ {
+		l, err := buf.LineAt(i)
+		if err != nil {
+			continue
+		}
+		out.WriteString(l.Output())
+		out.WriteByte('\n')
+	}
+	return errors.Wrap(out.Flush(), "failed to write results")
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"testing"
@@ -24,10 +26,12 @@ type nullHub struct{}
 
 func (h nullHub) Batch(_ context.Context, _ func(context.Context), _ bool)           {}
 func (h nullHub) DrawCh() chan hub.Payload                                           { return nil }
+func (h nullHub) EventCh() chan hub.Payload                                          { return nil }
 func (h nullHub) PagingCh() chan hub.Payload                                         { return nil }
 func (h nullHub) QueryCh() chan hub.Payload                                          { return nil }
 func (h nullHub) SendDraw(_ context.Context, _ interface{})                          {}
 func (h nullHub) SendDrawPrompt(context.Context)                                     {}
+func (h nullHub) SendEvent(_ context.Context, _ interface{})                         {}
 func (h nullHub) SendPaging(_ context.Context, _ interface{})                        {}
 func (h nullHub) SendQuery(_ context.Context, _ string)                              {}
 func (h nullHub) SendStatusMsg(_ context.Context, _ string)                          {}
@@ -489,3 +493,257 @@ func TestPrintQuery(t *testing.T) {
 		}
 	})
 }
+
+func TestFilterMode(t *testing.T) {
+	testValues := []struct {
+		name     string
+		argv     []string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Matching lines",
+			argv:     []string{"--filter", "oo"},
+			input:    "foo\nbar\nboo\n",
+			expected: "foo\nboo\n",
+		},
+		{
+			name:     "Empty query",
+			argv:     []string{"--filter", ""},
+			input:    "foo\nbar\n",
+			expected: "foo\nbar\n",
+		},
+		{
+			name:     "Print query",
+			argv:     []string{"--filter", "ar", "--print-query"},
+			input:    "foo\nbar\n",
+			expected: "ar\nbar\n",
+		},
+		{
+			name:     "Initial filter",
+			argv:     []string{"--filter", "^b", "--initial-filter", "Regexp"},
+			input:    "foo\nbar\nabc\n",
+			expected: "bar\n",
+		},
+		{
+			name:     "Null separator",
+			argv:     []string{"--filter", "foo", "--null"},
+			input:    "foo\x001\nbar\x002\n",
+			expected: "1\n",
+		},
+		{
+			name:     "Source command",
+			argv:     []string{"--filter", "oo", "--source-cmd", "printf 'foo\\nbar\\n'"},
+			expected: "foo\n",
+		},
+		{
+			name:     "Source command with query",
+			argv:     []string{"--filter", "a b", "--source-cmd", "echo {q}; echo c"},
+			expected: "a b\nc\n",
+		},
+		{
+			name:     "JSON",
+			argv:     []string{"--filter", "o", "--json", "--json-display", "{{.name}} {{.path}}", "--json-match", "{{.name}}", "--json-output", "{{.id}}"},
+			input:    `{"id": 1, "name": "foo", "path": "/x"}` + "\n" + `{"id": 2, "name": "bar", "path": "/o"}` + "\n",
+			expected: "1\n",
+		},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			p := newPeco()
+			p.Argv = v.argv
+			p.Stdin = bytes.NewBufferString(v.input)
+			p.screen = nil // the screen must not be used
+			var out bytes.Buffer
+			p.Stdout = &out
+
+			if !assert.NoError(t, p.Run(ctx), "p.Run should succeed") {
+				return
+			}
+			if !assert.Equal(t, v.expected, out.String(), "output should match") {
+				return
+			}
+		})
+	}
+}
+
+func TestReloadSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.hub = nullHub{}
+	go p.idgen.Run(ctx)
+
+	if !assert.Error(t, p.ReloadSource(ctx, ""), "ReloadSource should fail without a source command") {
+		return
+	}
+
+	p.sourceCmd = "echo {q}"
+	defer p.stopSourceCmd()
+
+	for _, q := range []string{"foo", "bar baz"} {
+		if !assert.NoError(t, p.ReloadSource(ctx, q), "ReloadSource should succeed") {
+			return
+		}
+
+		src := p.Source().(*Source)
+		select {
+		case <-ctx.Done():
+			assert.Fail(t, "timed out waiting for the source command")
+			return
+		case <-src.SetupDone():
+		}
+
+		b := p.CurrentLineBuffer()
+		if !assert.Equal(t, 1, b.Size(), "buffer should contain a single line") {
+			return
+		}
+		l, err := b.LineAt(0)
+		if !assert.NoError(t, err, "LineAt should succeed") {
+			return
+		}
+		if !assert.Equal(t, q, l.DisplayString(), "line should be the query") {
+			return
+		}
+	}
+}
+
+func TestSourceCmdQueryDelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "peco-source-cmd")
+	if !assert.NoError(t, err, "creating a directory should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "log")
+
+	p := newPeco()
+	p.hub = nullHub{}
+	p.config.QueryExecutionDelay = 300
+	go p.idgen.Run(ctx)
+
+	// The command records each query that it is executed with
+	var opts CLIOptions
+	opts.OptSourceCmd = "echo {q} >> " + logfile + "; echo {q}"
+	if !assert.NoError(t, p.ApplyConfig(opts), "p.ApplyConfig should succeed") {
+		return
+	}
+	if !assert.Equal(t, 300*time.Millisecond, p.QueryExecDelay(), "QueryExecutionDelay should be used") {
+		return
+	}
+	defer p.stopSourceCmd()
+	close(p.readyCh)
+
+	// Typing quickly executes the command once, with the last query
+	for _, q := range []string{"a", "ab", "abc"} {
+		p.Query().Set(q)
+		p.ExecQuery(nil)
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, err := os.Stat(logfile); !assert.True(t, os.IsNotExist(err), "command should not be executed before the delay") {
+		return
+	}
+
+	for {
+		b, _ := ioutil.ReadFile(logfile)
+		if len(b) > 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			assert.Fail(t, "timed out waiting for the source command")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	b, err := ioutil.ReadFile(logfile)
+	if !assert.NoError(t, err, "reading the log should succeed") {
+		return
+	}
+	assert.Equal(t, "abc\n", string(b), "command should be executed once with the last query")
+}
+
+func TestEvents(t *testing.T) {
+	testValues := []struct {
+		name     string
+		argv     []string
+		events   map[string][]string
+		expected string
+	}{
+		{
+			name:     "OneMatch",
+			argv:     []string{"--query", "fo"},
+			events:   map[string][]string{EventOneMatch: {"peco.Finish"}},
+			expected: "foo\n",
+		},
+		{
+			name: "Load",
+			events: map[string][]string{
+				EventLoad:     {`peco.SetQuery("ba")`},
+				EventOneMatch: {"peco.Finish"},
+			},
+			expected: "bar\n",
+		},
+		{
+			name: "ZeroMatch",
+			argv: []string{"--query", "xyz"},
+			events: map[string][]string{
+				EventZeroMatch: {`peco.SetQuery("oo")`},
+				EventOneMatch:  {"peco.Finish"},
+			},
+			expected: "foo\n",
+		},
+		{
+			name: "Change",
+			argv: []string{"--query", "xyz"},
+			events: map[string][]string{
+				EventChange:   {`peco.SetQuery("bar")`},
+				EventOneMatch: {"peco.Finish"},
+			},
+			expected: "bar\n",
+		},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			p := newPeco()
+			p.Argv = v.argv
+			p.Stdin = bytes.NewBufferString("foo\nbar\n")
+			p.config.Event = v.events
+			var out bytes.Buffer
+			p.Stdout = &out
+
+			err := p.Run(ctx)
+			if !assert.True(t, util.IsCollectResultsError(err), "p.Run should finish with results (got %v)", err) {
+				return
+			}
+			p.PrintResults()
+
+			if !assert.Equal(t, v.expected, out.String(), "output should match") {
+				return
+			}
+		})
+	}
+
+	t.Run("Unknown event", func(t *testing.T) {
+		km := NewKeymap(nil, nil)
+		if !assert.Error(t, km.ApplyEvents(map[string][]string{"NoSuchEvent": {"peco.Finish"}}), "ApplyEvents should fail") {
+			return
+		}
+		if !assert.Error(t, km.ApplyEvents(map[string][]string{EventLoad: {"peco.NoSuchAction"}}), "ApplyEvents should fail") {
+			return
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/lestrrat-go/pdebug"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+	"github.com/pkg/errors"
+)
+
+// previewMaxBytes is the maximum number of bytes of output from the
+// preview command that we keep. The rest is discarded
+const previewMaxBytes = 1024 * 1024
+
+// previewOutput collects the output of the preview command, up to
+// previewMaxBytes
+type previewOutput struct {
+	buf bytes.Buffer
+}
+
+func (o *previewOutput) Write(p []byte) (int, error) {
+	if n := previewMaxBytes - o.buf.Len(); n > 0 {
+		if len(p) > n {
+			o.buf.Write(p[:n])
+		} else {
+			o.buf.Write(p)
+		}
+	}
+	// Pretend that we wrote everything, so that the command does
+	// not fail because of us
+	return len(p), nil
+}
+
+// NewPreviewArea creates a new PreviewArea that runs the command
+// given in cfg. When the preview is placed after the list, its border
+// is drawn on the side that faces the list, which depends on whether
+// the list is sorted top-down or not
+func NewPreviewArea(screen Screen, cfg PreviewConfig, sortTopDown bool, styles *StyleSet) *PreviewArea {
+	pa := &PreviewArea{
+		screen:   screen,
+		styles:   styles,
+		command:  cfg.Command,
+		position: cfg.Position,
+		size:     cfg.Size,
+	}
+	if pa.position == PreviewPositionBottom {
+		if sortTopDown {
+			pa.border = AnchorTop
+		} else {
+			pa.border = AnchorBottom
+		}
+	}
+	return pa
+}
+
+// Lines returns the number of lines that the preview takes from the
+// list area, which has the given height. This is zero if the preview
+// is placed to the right of the list, or if there is no room for it
+func (pa *PreviewArea) Lines(height int) int {
+	if pa == nil || pa.position != PreviewPositionBottom {
+		return 0
+	}
+	// We need at least a line for the border, one for the preview,
+	// and one for the list
+	n := height * pa.size / 100
+	if n < 2 || height-n < 1 {
+		return 0
+	}
+	return n
+}
+
+// Update runs the preview command for the line under the cursor, if
+// it is not the line that the command was last run for. The command
+// that is still running for the previous line is canceled
+func (pa *PreviewArea) Update(state *Peco) {
+	l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber())
+	hasLine := err == nil
+
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+
+	if hasLine == pa.hasLine && (!hasLine || l.ID() == pa.lineID) {
+		return
+	}
+
+	if pa.cancel != nil {
+		pa.cancel()
+		pa.cancel = nil
+	}
+	pa.generation++
+	pa.hasLine = hasLine
+	pa.lines = nil
+	pa.offset = 0
+	if !hasLine {
+		return
+	}
+	pa.lineID = l.ID()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pa.cancel = cancel
+	go pa.run(ctx, state, pa.generation, expandLines(pa.command, []line.Line{l}))
+}
+
+// run runs the command, and stores its output if it is still the
+// latest command when it finishes
+func (pa *PreviewArea) run(ctx context.Context, state *Peco, generation uint64, command string) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("PreviewArea.run %s", command)
+		defer g.End()
+	}
+
+	var out previewOutput
+	cmd := util.Shell(command)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Start()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				cmd.Process.Kill()
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	text := strings.Replace(util.StripANSISequence(out.buf.String()), "\r", "", -1)
+	text = strings.TrimRight(text, "\n")
+	var lines []string
+	if text != "" {
+		lines = strings.Split(text, "\n")
+	}
+	if err != nil && len(lines) == 0 {
+		lines = []string{errors.Wrap(err, "preview command failed").Error()}
+	}
+
+	pa.mutex.Lock()
+	if pa.generation != generation {
+		pa.mutex.Unlock()
+		return
+	}
+	pa.lines = lines
+	pa.cancel = nil
+	pa.mutex.Unlock()
+
+	state.Hub().SendDraw(context.Background(), nil)
+}
+
+// Scroll scrolls the preview by n lines, or by n pages if page is
+// true. Negative values scroll up
+func (pa *PreviewArea) Scroll(n int, page bool) bool {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+
+	if page {
+		n *= maxOf(pa.height, 1)
+	}
+
+	offset := pa.offset + n
+	if max := len(pa.lines) - pa.height; offset > max {
+		offset = max
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset == pa.offset {
+		return false
+	}
+	pa.offset = offset
+	return true
+}
+
+// Draw draws the preview in the given area, including its border
+func (pa *PreviewArea) Draw(x, y, width, height int) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("PreviewArea.Draw (%d, %d, %d, %d)", x, y, width, height)
+		defer g.End()
+	}
+
+	fg := pa.styles.Basic.fg
+	bg := pa.styles.Basic.bg
+
+	// Draw the border, and shrink the area to what is inside it
+	switch pa.border {
+	case AnchorTop, AnchorBottom:
+		by := y
+		if pa.border == AnchorBottom {
+			by = y + height - 1
+		} else {
+			y++
+		}
+		height--
+		for i := 0; i < width; i++ {
+			pa.screen.SetCell(x+i, by, '─', fg, bg)
+		}
+	default:
+		for i := 0; i < height; i++ {
+			pa.screen.SetCell(x, y+i, '│', fg, bg)
+		}
+		x++
+	}
+
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+
+	pa.height = height
+	for i := 0; i < height; i++ {
+		var msg string
+		if n := pa.offset + i; n < len(pa.lines) {
+			msg = pa.lines[n]
+		}
+		pa.screen.Print(PrintArgs{
+			X:    x,
+			Y:    y + i,
+			Fg:   fg,
+			Bg:   bg,
+			Msg:  msg,
+			Fill: true,
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package peco
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/btree"
+	"github.com/lestrrat-go/pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/line"
+	"github.com/pkg/errors"
+)
+
+// listenRemote opens the socket given to --listen, and starts accepting
+// remote clients on it. Closing the returned listener removes the socket
+func (p *Peco) listenRemote(ctx context.Context) (net.Listener, error) {
+	ln, err := net.Listen("unix", p.listenPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen on %s", p.listenPath)
+	}
+
+	go p.serveRemote(ctx, ln)
+	return ln, nil
+}
+
+// serveRemote accepts connections from remote clients until the
+// listener is closed
+func (p *Peco) serveRemote(ctx context.Context, ln net.Listener) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.serveRemote %s", ln.Addr())
+		defer g.End()
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.handleRemoteConn(ctx, conn)
+	}
+}
+
+// handleRemoteConn reads requests from the client, which are JSON
+// objects, and writes a response for each of them
+func (p *Peco) handleRemoteConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	dec := json.NewDecoder(conn)
+	dec.UseNumber()
+	enc := json.NewEncoder(conn)
+	for {
+		var req remoteRequest
+		if err := dec.Decode(&req); err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				// We can't tell where the next request starts, so
+				// the connection is closed
+				enc.Encode(remoteResponse{Error: "invalid request: " + err.Error()})
+			}
+			return
+		}
+
+		if err := enc.Encode(p.handleRemoteRequest(ctx, req)); err != nil {
+			return
+		}
+	}
+}
+
+func (p *Peco) handleRemoteRequest(ctx context.Context, req remoteRequest) remoteResponse {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.handleRemoteRequest %#v", req)
+		defer g.End()
+	}
+
+	switch req.Type {
+	case "action":
+		if err := p.executeRemoteAction(ctx, req.Action, req.Args); err != nil {
+			return remoteResponse{Error: err.Error()}
+		}
+		return remoteResponse{OK: true}
+	case "state":
+		st, err := p.requestRemoteState(ctx)
+		if err != nil {
+			return remoteResponse{Error: err.Error()}
+		}
+		return remoteResponse{OK: true, State: &st}
+	default:
+		return remoteResponse{Error: fmt.Sprintf("unknown request type %q", req.Type)}
+	}
+}
+
+// executeRemoteAction executes the action with the given name and
+// arguments, and waits for it to finish. Like the actions bound to
+// events, it is executed along with the user's input, so that they do
+// not interleave
+func (p *Peco) executeRemoteAction(ctx context.Context, name string, args []interface{}) error {
+	if name == "" {
+		return errors.New("action name must not be empty")
+	}
+
+	if len(args) > 0 {
+		list := make([]string, len(args))
+		for i, arg := range args {
+			switch v := arg.(type) {
+			case string:
+				list[i] = strconv.Quote(v)
+			case json.Number:
+				list[i] = v.String()
+			default:
+				return errors.Errorf("argument %d must be a string or a number", i+1)
+			}
+		}
+		name += "(" + strings.Join(list, ", ") + ")"
+	}
+
+	errCh := make(chan error, 1)
+	a := ActionFunc(func(ctx context.Context, state *Peco, ev termbox.Event) {
+		km := state.Keymap()
+		action, err := km.resolveActionName(name, 0)
+		errCh <- err
+		if err != nil {
+			return
+		}
+		action.Execute(ctx, state, ev)
+	})
+
+	if err := p.remoteBatch(ctx, func(ctx context.Context) {
+		p.Hub().SendEvent(ctx, a)
+	}); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// remoteBatch sends the requests with f, and waits until they have
+// been handled. The loops that handle them stop when peco exits, so
+// it gives up waiting when ctx is canceled
+func (p *Peco) remoteBatch(ctx context.Context, f func(context.Context)) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Batch makes us wait until the requests have been handled
+		p.Hub().Batch(ctx, f, false)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return errors.New("peco is exiting")
+	case <-done:
+		return nil
+	}
+}
+
+// requestRemoteState asks the View for the state of peco, and waits
+// for it
+func (p *Peco) requestRemoteState(ctx context.Context) (remoteState, error) {
+	req := &remoteStateRequest{}
+	if err := p.remoteBatch(ctx, func(ctx context.Context) {
+		p.Hub().SendDraw(ctx, req)
+	}); err != nil {
+		return remoteState{}, err
+	}
+	return req.state, nil
+}
+
+// remoteState returns the current state of peco. It must be called
+// from the View, as it reads the location of the cursor
+func (p *Peco) remoteState() remoteState {
+	st := remoteState{
+		Query:     p.Query().String(),
+		Filter:    p.Filters().Current().String(),
+		Selection: []string{},
+	}
+
+	if src, ok := p.Source().(*Source); ok && src != nil {
+		st.Total = src.Size()
+	}
+
+	if b := p.CurrentLineBuffer(); b != nil {
+		st.Matched = b.Size()
+		if l, err := b.LineAt(p.Location().LineNumber()); err == nil {
+			st.Current = l.Output()
+		}
+	}
+
+	p.Selection().Ascend(func(it btree.Item) bool {
+		st.Selection = append(st.Selection, it.(line.Line).Output())
+		return true
+	})
+	return st
+}
@@ -0,0 +1,180 @@
+package peco
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/hub"
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemote(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-test-remote-")
+	if !assert.NoError(t, err, "ioutil.TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peco.sock")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = []string{"--listen", path}
+	p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+	var out bytes.Buffer
+	p.Stdout = &out
+
+	resultCh := make(chan error, 1)
+	go func() { resultCh <- p.Run(ctx) }()
+
+	var conn net.Conn
+	for conn == nil {
+		select {
+		case <-ctx.Done():
+			assert.Fail(t, "timed out waiting for the socket")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		conn, _ = net.Dial("unix", path)
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	request := func(req string) (res remoteResponse) {
+		conn.Write([]byte(req + "\n"))
+		b, err := r.ReadBytes('\n')
+		if assert.NoError(t, err, "reading the response should succeed") {
+			assert.NoError(t, json.Unmarshal(b, &res), "response should be JSON")
+		}
+		return res
+	}
+
+	// waitState polls the state until f returns true
+	waitState := func(f func(*remoteState) bool) *remoteState {
+		for {
+			res := request(`{"type": "state"}`)
+			if !assert.True(t, res.OK, "state request should succeed") {
+				return nil
+			}
+			if f(res.State) {
+				return res.State
+			}
+			select {
+			case <-ctx.Done():
+				assert.Fail(t, "timed out waiting for the state")
+				return nil
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	st := waitState(func(st *remoteState) bool { return st.Total == 3 && st.Matched == 3 })
+	if !assert.NotNil(t, st, "input should be read") {
+		return
+	}
+	if !assert.Equal(t, "foo", st.Current, "current line should be the first line") {
+		return
+	}
+
+	res := request(`{"type": "action", "action": "peco.SetQuery", "args": ["ba"]}`)
+	if !assert.True(t, res.OK, "SetQuery should succeed") {
+		return
+	}
+	st = waitState(func(st *remoteState) bool { return st.Matched == 2 })
+	if !assert.NotNil(t, st, "query should be executed") {
+		return
+	}
+	if !assert.Equal(t, "ba", st.Query, "query should be set") {
+		return
+	}
+
+	res = request(`{"type": "action", "action": "peco.SelectAll"}`)
+	if !assert.True(t, res.OK, "SelectAll should succeed") {
+		return
+	}
+	st = waitState(func(*remoteState) bool { return true })
+	if !assert.Equal(t, []string{"bar", "baz"}, st.Selection, "matching lines should be selected") {
+		return
+	}
+
+	for _, req := range []string{
+		`{"type": "action", "action": "peco.NoSuchAction"}`,
+		`{"type": "action", "action": "peco.SetQuery", "args": [true]}`,
+		`{"type": "action"}`,
+		`{"type": "unknown"}`,
+	} {
+		res = request(req)
+		if !assert.False(t, res.OK, "%s should fail", req) {
+			return
+		}
+		if !assert.NotEmpty(t, res.Error, "%s should report an error", req) {
+			return
+		}
+	}
+
+	conn.Write([]byte(`{"type": "action", "action": "peco.Finish"}` + "\n"))
+	select {
+	case <-ctx.Done():
+		assert.Fail(t, "timed out waiting for peco to finish")
+		return
+	case err := <-resultCh:
+		if !assert.True(t, util.IsCollectResultsError(err), "p.Run should finish with results (got %v)", err) {
+			return
+		}
+	}
+	p.PrintResults()
+
+	if !assert.Equal(t, "bar\nbaz\n", out.String(), "output should match") {
+		return
+	}
+	if _, err := os.Stat(path); !assert.True(t, os.IsNotExist(err), "socket should be removed") {
+		return
+	}
+}
+
+func TestRemoteShutdown(t *testing.T) {
+	for _, req := range []string{
+		`{"type": "state"}`,
+		`{"type": "action", "action": "peco.Finish"}`,
+	} {
+		t.Run(req, func(t *testing.T) {
+			// Nothing handles the requests that are sent to the hub,
+			// like after peco has exited
+			p := newPeco()
+			p.hub = hub.New(5)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			server, client := net.Pipe()
+			defer client.Close()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				p.handleRemoteConn(ctx, server)
+			}()
+
+			if _, err := client.Write([]byte(req + "\n")); !assert.NoError(t, err, "writing the request should succeed") {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				assert.Fail(t, "connection should be closed when peco exits")
+			}
+		})
+	}
+}
@@ -130,9 +130,78 @@ func (t *Termbox) Resume() {
 func (t *Termbox) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+
+	// termbox can only display 24-bit colors in its RGB mode, in which
+	// the palette colors are not available. Use the closest palette
+	// color instead
+	if isRGBColor(fg) || isRGBColor(bg) {
+		use256 := termbox.SetOutputMode(termbox.OutputCurrent) == termbox.Output256
+		fg = rgbToPalette(fg, use256)
+		bg = rgbToPalette(bg, use256)
+	}
 	termbox.SetCell(x, y, ch, fg, bg)
 }
 
+// rgbToPalette replaces the 24-bit color in attr, if any, with the
+// closest color of the 256 color palette, or of the 8 basic colors
+func rgbToPalette(attr termbox.Attribute, use256 bool) termbox.Attribute {
+	if !isRGBColor(attr) {
+		return attr
+	}
+
+	r, g, b := termbox.AttributeToRGB(attr)
+	attr &= rgbColorBase - 1
+	if !use256 {
+		// Each of the basic colors turns on some of red, green and blue
+		var i termbox.Attribute
+		if r >= 128 {
+			i |= 1
+		}
+		if g >= 128 {
+			i |= 2
+		}
+		if b >= 128 {
+			i |= 4
+		}
+		return attr | (termbox.ColorBlack + i)
+	}
+
+	// The 6x6x6 color cube starts at 16, and is followed by 24 grays
+	levels := []int{0, 95, 135, 175, 215, 255}
+	closest := func(v uint8) int {
+		best := 0
+		for i, l := range levels {
+			if abs(int(v)-l) < abs(int(v)-levels[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	ri, gi, bi := closest(r), closest(g), closest(b)
+	index := 16 + 36*ri + 6*gi + bi
+	dist := sq(int(r)-levels[ri]) + sq(int(g)-levels[gi]) + sq(int(b)-levels[bi])
+
+	gray := (int(r) + int(g) + int(b)) / 3
+	if gi := (gray - 8 + 5) / 10; gi >= 0 && gi < 24 {
+		l := 8 + 10*gi
+		if d := sq(int(r)-l) + sq(int(g)-l) + sq(int(b)-l); d < dist {
+			index = 232 + gi
+		}
+	}
+	return attr | termbox.Attribute(index+1)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func sq(v int) int {
+	return v * v
+}
+
 // Size returns the dimensions of the current terminal
 func (t *Termbox) Size() (int, int) {
 	t.mutex.Lock()
@@ -0,0 +1,353 @@
+package peco
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+	"github.com/pkg/errors"
+)
+
+// minInlineHeight is the smallest number of lines that the prompt,
+// the status bar and at least one line fit in
+const minInlineHeight = 3
+
+// NewInlineScreen creates a new InlineScreen. height is either a
+// number of lines, such as "10", or a percentage of the height of the
+// terminal, such as "40%"
+func NewInlineScreen(height string) (*InlineScreen, error) {
+	s := &InlineScreen{
+		done: make(chan struct{}),
+	}
+
+	v := height
+	if strings.HasSuffix(v, "%") {
+		s.percent = true
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 || (s.percent && n > 100) {
+		return nil, errors.Errorf("invalid height %s", height)
+	}
+	s.height = n
+	return s, nil
+}
+
+// rowsFor returns the number of lines that peco is drawn in on a
+// terminal of the given height
+func (s *InlineScreen) rowsFor(termHeight int) int {
+	rows := s.height
+	if s.percent {
+		rows = termHeight * s.height / 100
+	}
+	if rows < minInlineHeight {
+		rows = minInlineHeight
+	}
+	if rows > termHeight {
+		rows = termHeight
+	}
+	return rows
+}
+
+// setSize must be called while holding the lock
+func (s *InlineScreen) setSize(width, rows int) {
+	s.width = width
+	s.rows = rows
+	s.back = newVirtualCells(width * rows)
+	s.front = newVirtualCells(width * rows)
+	s.redraw = true
+}
+
+func (s *InlineScreen) Print(args PrintArgs) int {
+	return screenPrint(s, args)
+}
+
+// SendEvent is a noop, like it is for Termbox
+func (s *InlineScreen) SendEvent(_ termbox.Event) {}
+
+// SetCell draws a character. Cells outside of the screen are ignored
+func (s *InlineScreen) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if x < 0 || y < 0 || x >= s.width || y >= s.rows {
+		return
+	}
+	i := y*s.width + x
+	s.back[i] = virtualCell{ch: ch, fg: fg, bg: bg}
+	if runewidth.RuneWidth(ch) == 2 && x+1 < s.width {
+		s.back[i+1] = virtualCell{fg: fg, bg: bg}
+	}
+}
+
+func (s *InlineScreen) SetCursor(x, y int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cursorX = x
+	s.cursorY = y
+}
+
+// Size returns the size of the area that peco is drawn in
+func (s *InlineScreen) Size() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.width, s.rows
+}
+
+// Flush writes the lines that changed since the last flush to the
+// terminal
+func (s *InlineScreen) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.out == nil || s.suspended {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[?25l") // hide the cursor while drawing
+	for y := 0; y < s.rows; y++ {
+		row := s.back[y*s.width : (y+1)*s.width]
+		if !s.redraw && cellsEqual(row, s.front[y*s.width:(y+1)*s.width]) {
+			continue
+		}
+		s.moveTo(&buf, 0, y)
+		writeInlineRow(&buf, row)
+	}
+	copy(s.front, s.back)
+	s.redraw = false
+
+	s.moveTo(&buf, s.cursorX, s.cursorY)
+	buf.WriteString("\x1b[?25h")
+
+	_, err := s.out.Write(buf.Bytes())
+	return errors.Wrap(err, "failed to write to terminal")
+}
+
+// moveTo moves the cursor to the given position in the area. The
+// position of the line above the area is saved when the area is
+// reserved, so only relative movements are needed
+func (s *InlineScreen) moveTo(buf *bytes.Buffer, x, y int) {
+	buf.WriteString("\x1b8\x1b[" + strconv.Itoa(y+1) + "B\r")
+	if x > 0 {
+		buf.WriteString("\x1b[" + strconv.Itoa(x) + "C")
+	}
+}
+
+// reserveSequence returns the escape sequence that makes room for the
+// area below the cursor, scrolling the terminal if necessary, and saves
+// the position of the cursor
+func reserveSequence(rows int) string {
+	return strings.Repeat("\n", rows) + "\x1b[" + strconv.Itoa(rows) + "A\x1b7"
+}
+
+// clearSequence is the escape sequence that clears the area, and
+// leaves the cursor at its top left corner
+const clearSequence = "\x1b8\x1b[1B\r\x1b[0m\x1b[J"
+
+func cellsEqual(a, b []virtualCell) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeInlineRow writes the cells of a line, and clears the rest of the
+// line unless the cells fill it
+func writeInlineRow(buf *bytes.Buffer, row []virtualCell) {
+	end := len(row)
+	for end > 0 && (row[end-1].ch == ' ' || row[end-1].ch == 0) && isDefaultCell(row[end-1]) {
+		end--
+	}
+
+	var fg, bg termbox.Attribute
+	buf.WriteString("\x1b[0m")
+	for _, c := range row[:end] {
+		if c.ch == 0 {
+			continue
+		}
+		if c.fg != fg || c.bg != bg {
+			buf.WriteString(sgrSequence(c.fg, c.bg))
+			fg, bg = c.fg, c.bg
+		}
+		buf.WriteRune(c.ch)
+	}
+	buf.WriteString("\x1b[0m")
+
+	// Clearing the line right after the last column would clear the
+	// character in the last column on some terminals
+	if end < len(row) {
+		buf.WriteString("\x1b[K")
+	}
+}
+
+// sgrSequence returns the escape sequence that sets the style given by
+// the termbox attributes
+func sgrSequence(fg, bg termbox.Attribute) string {
+	params := []string{"0"}
+	if fg&termbox.AttrBold != 0 {
+		params = append(params, "1")
+	}
+	if fg&termbox.AttrUnderline != 0 {
+		params = append(params, "4")
+	}
+	if (fg|bg)&termbox.AttrReverse != 0 {
+		params = append(params, "7")
+	}
+	if p := sgrColor(fg, 30, 38); p != "" {
+		params = append(params, p)
+	}
+	if p := sgrColor(bg, 40, 48); p != "" {
+		params = append(params, p)
+	}
+	return "\x1b[" + strings.Join(params, ";") + "m"
+}
+
+func sgrColor(attr termbox.Attribute, basic, extended int) string {
+	if isRGBColor(attr) {
+		r, g, b := termbox.AttributeToRGB(attr)
+		return strconv.Itoa(extended) + ";2;" + strconv.Itoa(int(r)) + ";" + strconv.Itoa(int(g)) + ";" + strconv.Itoa(int(b))
+	}
+
+	color := int(attr & 0x1ff)
+	switch {
+	case color == 0:
+		return ""
+	case color <= 8:
+		return strconv.Itoa(basic + color - 1)
+	default:
+		return strconv.Itoa(extended) + ";5;" + strconv.Itoa(color-1)
+	}
+}
+
+var csiKeys = map[byte]termbox.Key{
+	'A': termbox.KeyArrowUp,
+	'B': termbox.KeyArrowDown,
+	'C': termbox.KeyArrowRight,
+	'D': termbox.KeyArrowLeft,
+	'H': termbox.KeyHome,
+	'F': termbox.KeyEnd,
+	'P': termbox.KeyF1,
+	'Q': termbox.KeyF2,
+	'R': termbox.KeyF3,
+	'S': termbox.KeyF4,
+}
+
+var csiTildeKeys = map[int]termbox.Key{
+	1:  termbox.KeyHome,
+	2:  termbox.KeyInsert,
+	3:  termbox.KeyDelete,
+	4:  termbox.KeyEnd,
+	5:  termbox.KeyPgup,
+	6:  termbox.KeyPgdn,
+	7:  termbox.KeyHome,
+	8:  termbox.KeyEnd,
+	11: termbox.KeyF1,
+	12: termbox.KeyF2,
+	13: termbox.KeyF3,
+	14: termbox.KeyF4,
+	15: termbox.KeyF5,
+	17: termbox.KeyF6,
+	18: termbox.KeyF7,
+	19: termbox.KeyF8,
+	20: termbox.KeyF9,
+	21: termbox.KeyF10,
+	23: termbox.KeyF11,
+	24: termbox.KeyF12,
+}
+
+// decodeInlineInput decodes the first key in the input read from the
+// terminal. It returns the number of bytes that were consumed, which
+// is 0 if more input is needed, and false if the bytes did not make a
+// key that peco knows
+func decodeInlineInput(b []byte) (termbox.Event, int, bool) {
+	ev := termbox.Event{Type: termbox.EventKey}
+	if len(b) == 0 {
+		return ev, 0, false
+	}
+
+	if b[0] != 0x1b {
+		switch {
+		case b[0] == ' ':
+			ev.Key = termbox.KeySpace
+			return ev, 1, true
+		case b[0] < 0x20 || b[0] == 0x7f:
+			// Control keys are reported as their ASCII codes, like
+			// termbox does
+			ev.Key = termbox.Key(b[0])
+			return ev, 1, true
+		}
+
+		if !utf8.FullRune(b) {
+			return ev, 0, false
+		}
+		r, n := utf8.DecodeRune(b)
+		if r == utf8.RuneError {
+			return ev, n, false
+		}
+		ev.Ch = r
+		return ev, n, true
+	}
+
+	// Input is read as the terminal sends it, so an Esc that is not
+	// followed by anything was the Esc key itself
+	if len(b) == 1 {
+		ev.Key = termbox.KeyEsc
+		return ev, 1, true
+	}
+
+	if b[1] != '[' && b[1] != 'O' {
+		// Esc followed by a key is the key with Alt
+		ev, n, ok := decodeInlineInput(b[1:])
+		if n == 0 {
+			return ev, 0, false
+		}
+		ev.Mod = termbox.ModAlt
+		return ev, n + 1, ok
+	}
+
+	// CSI (Esc [) and SS3 (Esc O) sequences end with a letter or ~, and
+	// may have numeric parameters before that
+	i := 2
+	for i < len(b) && (b[i] >= '0' && b[i] <= '9' || b[i] == ';') {
+		i++
+	}
+	if i >= len(b) {
+		if len(b) == 2 && b[1] == '[' {
+			// Alt+[
+			ev.Ch = '['
+			ev.Mod = termbox.ModAlt
+			return ev, 2, true
+		}
+		return ev, 0, false
+	}
+	n := i + 1
+
+	params := strings.Split(string(b[2:i]), ";")
+	if len(params) > 1 {
+		// The second parameter tells which modifiers were pressed,
+		// and 3 (or 4 with Shift) means Alt
+		if m, err := strconv.Atoi(params[1]); err == nil && (m-1)&2 != 0 {
+			ev.Mod = termbox.ModAlt
+		}
+	}
+
+	if b[i] == '~' {
+		code, err := strconv.Atoi(params[0])
+		if err != nil {
+			return ev, n, false
+		}
+		key, ok := csiTildeKeys[code]
+		ev.Key = key
+		return ev, n, ok
+	}
+
+	key, ok := csiKeys[b[i]]
+	ev.Key = key
+	return ev, n, ok
+}
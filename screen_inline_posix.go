@@ -0,0 +1,267 @@
+// +build !windows
+
+package peco
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	pdebug "github.com/lestrrat-go/pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// Init opens the terminal, and makes room for peco below the cursor
+func (s *InlineScreen) Init(_ *Config) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tty, _, err := util.OpenTerminal()
+	if err != nil {
+		return errors.Wrap(err, "failed to open terminal")
+	}
+
+	fd := int(tty.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		tty.Close()
+		return errors.Wrap(err, "failed to put terminal in raw mode")
+	}
+
+	width, height, err := term.GetSize(fd)
+	if err != nil {
+		term.Restore(fd, state)
+		tty.Close()
+		return errors.Wrap(err, "failed to get terminal size")
+	}
+
+	s.tty = tty
+	s.out = tty
+	s.ttyState = state
+	s.setSize(width, s.rowsFor(height))
+	_, err = s.out.Write([]byte(reserveSequence(s.rows)))
+	return errors.Wrap(err, "failed to write to terminal")
+}
+
+// Close clears the area that peco was drawn in, and puts the cursor
+// back where it was
+func (s *InlineScreen) Close() error {
+	if pdebug.Enabled {
+		pdebug.Printf("InlineScreen: Close")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+
+	// peco may exit before the screen is initialized
+	if s.tty == nil {
+		return nil
+	}
+
+	if !s.suspended {
+		s.out.Write([]byte(clearSequence + "\x1b8\x1b[?25h"))
+		term.Restore(int(s.tty.Fd()), s.ttyState)
+	}
+	return s.tty.Close()
+}
+
+// Suspend clears the area and gives the terminal back, so that
+// commands can use it
+func (s *InlineScreen) Suspend() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tty == nil || s.suspended {
+		return
+	}
+	s.suspended = true
+	s.resumed = make(chan struct{})
+	s.out.Write([]byte(clearSequence + "\x1b[?25h"))
+	term.Restore(int(s.tty.Fd()), s.ttyState)
+}
+
+// Resume makes room for peco again, below whatever the commands that
+// were executed while the screen was suspended displayed
+func (s *InlineScreen) Resume() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tty == nil || !s.suspended {
+		return
+	}
+
+	fd := int(s.tty.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("InlineScreen: failed to put terminal in raw mode: %s", err)
+		}
+		return
+	}
+	s.ttyState = state
+	s.suspended = false
+	close(s.resumed)
+	s.redraw = true
+	s.out.Write([]byte(reserveSequence(s.rows)))
+}
+
+// resize makes room for the area again after the terminal has been
+// resized, and returns the new size of the area
+func (s *InlineScreen) resize() (int, int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	width, height, err := term.GetSize(int(s.tty.Fd()))
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get terminal size")
+	}
+
+	if !s.suspended {
+		s.out.Write([]byte(clearSequence + "\x1b8" + reserveSequence(s.rowsFor(height))))
+	}
+	s.setSize(width, s.rowsFor(height))
+	return s.width, s.rows, nil
+}
+
+// isSuspended returns true if the terminal must be left to the
+// commands that are being executed
+func (s *InlineScreen) isSuspended() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.suspended
+}
+
+// waitResumed waits until the screen is resumed, if the terminal has
+// been left to the commands that are being executed. It returns false
+// if the screen was closed, or ctx was canceled in the meantime
+func (s *InlineScreen) waitResumed(ctx context.Context) bool {
+	s.mutex.Lock()
+	suspended, resumed := s.suspended, s.resumed
+	s.mutex.Unlock()
+	if !suspended {
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-s.done:
+		return false
+	case <-resumed:
+		return true
+	}
+}
+
+// PollEvent returns a channel that receives the keys that are read
+// from the terminal, and resize events
+func (s *InlineScreen) PollEvent(ctx context.Context, _ *Config) chan termbox.Event {
+	evCh := make(chan termbox.Event)
+
+	send := func(ev termbox.Event) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-s.done:
+			return false
+		case evCh <- ev:
+			return true
+		}
+	}
+
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGWINCH)
+		defer signal.Stop(sigCh)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.done:
+				return
+			case <-sigCh:
+				w, h, err := s.resize()
+				if err != nil {
+					send(termbox.Event{Type: termbox.EventError, Err: err})
+					continue
+				}
+				send(termbox.Event{Type: termbox.EventResize, Width: w, Height: h})
+			}
+		}
+	}()
+
+	go func() {
+		defer close(evCh)
+
+		// The terminal is polled with a timeout rather than read
+		// directly, so that this goroutine can stop reading while the
+		// screen is suspended, and when it is closed
+		fd := int(s.tty.Fd())
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		buf := make([]byte, 256)
+		var pending []byte
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.done:
+				return
+			default:
+			}
+
+			// What is typed while the screen is suspended is left
+			// for the commands to read
+			if !s.waitResumed(ctx) {
+				return
+			}
+
+			n, err := unix.Poll(fds, 100)
+			if err == unix.EINTR || n == 0 {
+				continue
+			}
+			if err != nil {
+				send(termbox.Event{Type: termbox.EventError, Err: err})
+				return
+			}
+
+			// The screen may have been suspended while polling
+			if s.isSuspended() {
+				continue
+			}
+
+			n, err = unix.Read(fd, buf)
+			if err == unix.EINTR || err == unix.EAGAIN {
+				continue
+			}
+			if err != nil || n == 0 {
+				send(termbox.Event{Type: termbox.EventError, Err: errors.Wrap(err, "failed to read from terminal")})
+				return
+			}
+
+			pending = append(pending, buf[:n]...)
+			for len(pending) > 0 {
+				ev, consumed, ok := decodeInlineInput(pending)
+				if consumed == 0 {
+					break
+				}
+				pending = pending[consumed:]
+				if ok && !send(ev) {
+					return
+				}
+			}
+		}
+	}()
+	return evCh
+}
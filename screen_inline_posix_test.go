@@ -0,0 +1,63 @@
+// +build !windows
+
+package peco
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInlineScreenWaitResumed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := NewInlineScreen("10")
+	if !assert.NoError(t, err, "NewInlineScreen should succeed") {
+		return
+	}
+	if !assert.True(t, s.waitResumed(ctx), "screen that is not suspended should not wait") {
+		return
+	}
+
+	// Suspend and Resume need a terminal, so the screen is suspended
+	// in the same way that Suspend does
+	s.mutex.Lock()
+	s.suspended = true
+	s.resumed = make(chan struct{})
+	s.mutex.Unlock()
+
+	resultCh := make(chan bool, 1)
+	go func() { resultCh <- s.waitResumed(ctx) }()
+
+	select {
+	case <-resultCh:
+		assert.Fail(t, "waitResumed should block while the screen is suspended")
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	s.mutex.Lock()
+	s.suspended = false
+	close(s.resumed)
+	s.mutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		assert.Fail(t, "waitResumed should return when the screen is resumed")
+	case ok := <-resultCh:
+		if !assert.True(t, ok, "waitResumed should succeed") {
+			return
+		}
+	}
+
+	// Closing the screen stops the wait
+	s.mutex.Lock()
+	s.suspended = true
+	s.resumed = make(chan struct{})
+	s.mutex.Unlock()
+	s.Close()
+	assert.False(t, s.waitResumed(ctx), "waitResumed should fail when the screen is closed")
+}
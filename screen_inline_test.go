@@ -0,0 +1,154 @@
+package peco
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewInlineScreen(t *testing.T) {
+	tests := []struct {
+		height     string
+		termHeight int
+		rows       int
+	}{
+		{"10", 40, 10},
+		{"10", 8, 8},
+		{"1", 40, minInlineHeight},
+		{"40%", 40, 16},
+		{"100%", 40, 40},
+	}
+	for _, test := range tests {
+		s, err := NewInlineScreen(test.height)
+		if !assert.NoError(t, err, "NewInlineScreen should succeed for %s", test.height) {
+			return
+		}
+		if !assert.Equal(t, test.rows, s.rowsFor(test.termHeight), "number of lines should match for %s", test.height) {
+			return
+		}
+	}
+
+	for _, height := range []string{"", "0", "-1", "101%", "%", "ten"} {
+		_, err := NewInlineScreen(height)
+		if !assert.Error(t, err, "NewInlineScreen should fail for %s", height) {
+			return
+		}
+	}
+
+	p := New()
+	if !assert.NoError(t, p.populateScreen(CLIOptions{OptHeight: "10", OptScreen: ScreenTypeTcell}), "populateScreen should succeed") {
+		return
+	}
+	if !assert.IsType(t, &InlineScreen{}, p.screen, "--height should take precedence") {
+		return
+	}
+}
+
+func TestDecodeInlineInput(t *testing.T) {
+	key := func(k termbox.Key) termbox.Event {
+		return termbox.Event{Type: termbox.EventKey, Key: k}
+	}
+	ch := func(r rune) termbox.Event {
+		return termbox.Event{Type: termbox.EventKey, Ch: r}
+	}
+	alt := func(ev termbox.Event) termbox.Event {
+		ev.Mod = termbox.ModAlt
+		return ev
+	}
+
+	tests := []struct {
+		input    string
+		expected termbox.Event
+		consumed int
+	}{
+		{"a", ch('a'), 1},
+		{"日本", ch('日'), 3},
+		{" ", key(termbox.KeySpace), 1},
+		{"\x0e", key(termbox.KeyCtrlN), 1},
+		{"\r", key(termbox.KeyEnter), 1},
+		{"\x7f", key(termbox.KeyBackspace2), 1},
+		{"\x1b", key(termbox.KeyEsc), 1},
+		{"\x1bv", alt(ch('v')), 2},
+		{"\x1b[Bx", key(termbox.KeyArrowDown), 3},
+		{"\x1bOA", key(termbox.KeyArrowUp), 3},
+		{"\x1b[1;3C", alt(key(termbox.KeyArrowRight)), 6},
+		{"\x1b[6~", key(termbox.KeyPgdn), 4},
+		{"\x1b[3~", key(termbox.KeyDelete), 4},
+		{"\x1b[15~", key(termbox.KeyF5), 5},
+		{"\x1bOP", key(termbox.KeyF1), 3},
+	}
+	for _, test := range tests {
+		ev, n, ok := decodeInlineInput([]byte(test.input))
+		if !assert.True(t, ok, "%q should be decoded", test.input) {
+			return
+		}
+		if !assert.Equal(t, test.expected, ev, "event should match for %q", test.input) {
+			return
+		}
+		if !assert.Equal(t, test.consumed, n, "number of bytes should match for %q", test.input) {
+			return
+		}
+	}
+
+	// Incomplete input waits for more
+	for _, input := range []string{"\xe6\x97", "\x1b[1;"} {
+		_, n, _ := decodeInlineInput([]byte(input))
+		if !assert.Equal(t, 0, n, "%q should need more input", input) {
+			return
+		}
+	}
+
+	// Unknown sequences are skipped
+	_, n, ok := decodeInlineInput([]byte("\x1b[99~a"))
+	if !assert.False(t, ok, "unknown sequence should not be decoded") {
+		return
+	}
+	if !assert.Equal(t, 5, n, "unknown sequence should be consumed") {
+		return
+	}
+}
+
+func TestInlineScreenFlush(t *testing.T) {
+	s, err := NewInlineScreen("3")
+	if !assert.NoError(t, err, "NewInlineScreen should succeed") {
+		return
+	}
+
+	var out bytes.Buffer
+	s.out = &out
+	s.setSize(10, 3)
+
+	s.Print(PrintArgs{X: 0, Y: 0, Msg: "QUERY>"})
+	s.Print(PrintArgs{X: 0, Y: 1, Msg: "foo", Fg: termbox.ColorCyan | termbox.AttrBold, Bg: termbox.RGBToAttribute(0x20, 0x20, 0x20)})
+	s.Print(PrintArgs{X: 0, Y: 2, Msg: "0123456789", Fg: termbox.Attribute(101)})
+	s.SetCursor(6, 0)
+	if !assert.NoError(t, s.Flush(), "Flush should succeed") {
+		return
+	}
+
+	expected := "\x1b[?25l" +
+		"\x1b8\x1b[1B\r\x1b[0mQUERY>\x1b[0m\x1b[K" +
+		"\x1b8\x1b[2B\r\x1b[0m\x1b[0;1;36;48;2;32;32;32mfoo\x1b[0m\x1b[K" +
+		"\x1b8\x1b[3B\r\x1b[0m\x1b[0;38;5;100m0123456789\x1b[0m" +
+		"\x1b8\x1b[1B\r\x1b[6C\x1b[?25h"
+	if !assert.Equal(t, expected, out.String(), "all lines should be drawn") {
+		return
+	}
+
+	// Only the lines that changed are drawn again
+	out.Reset()
+	s.Print(PrintArgs{X: 0, Y: 1, Msg: "bar"})
+	s.SetCursor(0, 0)
+	if !assert.NoError(t, s.Flush(), "Flush should succeed") {
+		return
+	}
+
+	expected = "\x1b[?25l" +
+		"\x1b8\x1b[2B\r\x1b[0mbar\x1b[0m\x1b[K" +
+		"\x1b8\x1b[1B\r\x1b[?25h"
+	if !assert.Equal(t, expected, out.String(), "only the changed line should be drawn") {
+		return
+	}
+}
@@ -0,0 +1,25 @@
+package peco
+
+import (
+	"context"
+
+	"github.com/nsf/termbox-go"
+	"github.com/pkg/errors"
+)
+
+// Init fails, because the Windows console is not driven with escape
+// sequences
+func (s *InlineScreen) Init(_ *Config) error {
+	return errors.New("--height is not supported on Windows")
+}
+
+func (s *InlineScreen) Close() error {
+	return nil
+}
+
+func (s *InlineScreen) Suspend() {}
+func (s *InlineScreen) Resume()  {}
+
+func (s *InlineScreen) PollEvent(_ context.Context, _ *Config) chan termbox.Event {
+	return make(chan termbox.Event)
+}
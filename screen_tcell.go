@@ -0,0 +1,216 @@
+package peco
+
+import (
+	"context"
+
+	"github.com/gdamore/tcell/v2"
+	pdebug "github.com/lestrrat-go/pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/pkg/errors"
+)
+
+// NewTcell creates a new Tcell screen
+func NewTcell() *Tcell {
+	return &Tcell{}
+}
+
+func (t *Tcell) Init(_ *Config) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// The screen is only created here, unless it was given by the tests
+	s := t.screen
+	if s == nil {
+		var err error
+		if s, err = tcell.NewScreen(); err != nil {
+			return errors.Wrap(err, "failed to create tcell screen")
+		}
+	}
+	if err := s.Init(); err != nil {
+		return errors.Wrap(err, "failed to initialize tcell")
+	}
+	t.screen = s
+	return nil
+}
+
+func (t *Tcell) Close() error {
+	if pdebug.Enabled {
+		pdebug.Printf("Tcell: Close")
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// peco may exit before the screen is initialized
+	if t.screen == nil {
+		return nil
+	}
+	// Fini makes PollEvent return nil, which stops the polling goroutine
+	t.screen.Fini()
+	return nil
+}
+
+func (t *Tcell) SetCursor(x, y int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.screen.ShowCursor(x, y)
+}
+
+// SendEvent is a noop, like it is for Termbox
+func (t *Tcell) SendEvent(_ termbox.Event) {}
+
+// Flush makes the cells that were set visible
+func (t *Tcell) Flush() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.screen.Show()
+	return nil
+}
+
+// PollEvent returns a channel that receives the events from tcell,
+// converted to the equivalent termbox events
+func (t *Tcell) PollEvent(ctx context.Context, _ *Config) chan termbox.Event {
+	evCh := make(chan termbox.Event)
+
+	go func() {
+		defer close(evCh)
+
+		for {
+			tev := t.screen.PollEvent()
+			if tev == nil {
+				return
+			}
+
+			ev, ok := tcellToTermboxEvent(tev)
+			if !ok {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case evCh <- ev:
+			}
+		}
+	}()
+	return evCh
+}
+
+func (t *Tcell) Suspend() {
+	if err := t.screen.Suspend(); err != nil && pdebug.Enabled {
+		pdebug.Printf("Tcell: failed to suspend: %s", err)
+	}
+}
+
+// Resume restores the screen. tcell keeps polling events while it is
+// suspended, so unlike Termbox there is nothing else to restart
+func (t *Tcell) Resume() {
+	if err := t.screen.Resume(); err != nil && pdebug.Enabled {
+		pdebug.Printf("Tcell: failed to resume: %s", err)
+	}
+}
+
+// SetCell writes to the terminal
+func (t *Tcell) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.screen.SetContent(x, y, ch, nil, tcellStyle(fg, bg))
+}
+
+// Size returns the dimensions of the current terminal
+func (t *Tcell) Size() (int, int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.screen.Size()
+}
+
+func (t *Tcell) Print(args PrintArgs) int {
+	return screenPrint(t, args)
+}
+
+// tcellStyle converts the termbox attributes that peco uses for styles
+// to a tcell style, applying them the way termbox does
+func tcellStyle(fg, bg termbox.Attribute) tcell.Style {
+	return tcell.StyleDefault.
+		Foreground(tcellColor(fg)).
+		Background(tcellColor(bg)).
+		Bold(fg&termbox.AttrBold != 0).
+		Underline(fg&termbox.AttrUnderline != 0).
+		Reverse((fg|bg)&termbox.AttrReverse != 0)
+}
+
+func tcellColor(attr termbox.Attribute) tcell.Color {
+	if isRGBColor(attr) {
+		r, g, b := termbox.AttributeToRGB(attr)
+		return tcell.NewRGBColor(int32(r), int32(g), int32(b))
+	}
+
+	// Colors are numbered from 1 in termbox, and 0 is the default
+	color := int(attr & 0x1ff)
+	if color == 0 {
+		return tcell.ColorDefault
+	}
+	return tcell.PaletteColor(color - 1)
+}
+
+var tcellToTermboxKeys = map[tcell.Key]termbox.Key{
+	tcell.KeyUp:     termbox.KeyArrowUp,
+	tcell.KeyDown:   termbox.KeyArrowDown,
+	tcell.KeyLeft:   termbox.KeyArrowLeft,
+	tcell.KeyRight:  termbox.KeyArrowRight,
+	tcell.KeyInsert: termbox.KeyInsert,
+	tcell.KeyDelete: termbox.KeyDelete,
+	tcell.KeyHome:   termbox.KeyHome,
+	tcell.KeyEnd:    termbox.KeyEnd,
+	tcell.KeyPgUp:   termbox.KeyPgup,
+	tcell.KeyPgDn:   termbox.KeyPgdn,
+	tcell.KeyF1:     termbox.KeyF1,
+	tcell.KeyF2:     termbox.KeyF2,
+	tcell.KeyF3:     termbox.KeyF3,
+	tcell.KeyF4:     termbox.KeyF4,
+	tcell.KeyF5:     termbox.KeyF5,
+	tcell.KeyF6:     termbox.KeyF6,
+	tcell.KeyF7:     termbox.KeyF7,
+	tcell.KeyF8:     termbox.KeyF8,
+	tcell.KeyF9:     termbox.KeyF9,
+	tcell.KeyF10:    termbox.KeyF10,
+	tcell.KeyF11:    termbox.KeyF11,
+	tcell.KeyF12:    termbox.KeyF12,
+}
+
+// tcellToTermboxEvent converts a tcell event to the termbox event that
+// the input loop expects. Events that peco does not handle are dropped
+func tcellToTermboxEvent(tev tcell.Event) (termbox.Event, bool) {
+	switch tev := tev.(type) {
+	case *tcell.EventResize:
+		w, h := tev.Size()
+		return termbox.Event{Type: termbox.EventResize, Width: w, Height: h}, true
+	case *tcell.EventError:
+		return termbox.Event{Type: termbox.EventError, Err: tev}, true
+	case *tcell.EventKey:
+		ev := termbox.Event{Type: termbox.EventKey}
+		if tev.Modifiers()&tcell.ModAlt != 0 {
+			ev.Mod = termbox.ModAlt
+		}
+
+		switch key := tev.Key(); {
+		case key == tcell.KeyRune:
+			// termbox reports the space bar as a key
+			if tev.Rune() == ' ' {
+				ev.Key = termbox.KeySpace
+			} else {
+				ev.Ch = tev.Rune()
+			}
+		case key <= tcell.KeyDEL:
+			// Control keys have the same ASCII codes in both libraries
+			ev.Key = termbox.Key(key)
+		default:
+			k, ok := tcellToTermboxKeys[key]
+			if !ok {
+				return ev, false
+			}
+			ev.Key = k
+		}
+		return ev, true
+	}
+	return termbox.Event{}, false
+}
@@ -0,0 +1,143 @@
+package peco
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTcellStyle(t *testing.T) {
+	var style Style
+	if !assert.NoError(t, stringsToStyle(&style, []string{"#ff8800", "on_240", "bold", "underline"}), "stringsToStyle should succeed") {
+		return
+	}
+
+	expected := tcell.StyleDefault.
+		Foreground(tcell.NewRGBColor(0xff, 0x88, 0x00)).
+		Background(tcell.PaletteColor(240)).
+		Bold(true).
+		Underline(true)
+	if !assert.Equal(t, expected, tcellStyle(style.fg, style.bg), "styles should match") {
+		return
+	}
+
+	// termbox red is the first color of the palette, which tcell calls maroon
+	expected = tcell.StyleDefault.Foreground(tcell.ColorMaroon).Background(tcell.ColorDefault).Reverse(true)
+	if !assert.Equal(t, expected, tcellStyle(termbox.ColorRed|termbox.AttrReverse, termbox.ColorDefault), "styles should match") {
+		return
+	}
+}
+
+func TestTcellToTermboxEvent(t *testing.T) {
+	tests := []struct {
+		event    tcell.Event
+		expected termbox.Event
+	}{
+		{tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone), termbox.Event{Type: termbox.EventKey, Ch: 'a'}},
+		{tcell.NewEventKey(tcell.KeyRune, 'v', tcell.ModAlt), termbox.Event{Type: termbox.EventKey, Ch: 'v', Mod: termbox.ModAlt}},
+		{tcell.NewEventKey(tcell.KeyRune, ' ', tcell.ModNone), termbox.Event{Type: termbox.EventKey, Key: termbox.KeySpace}},
+		{tcell.NewEventKey(tcell.KeyCtrlN, 0, tcell.ModCtrl), termbox.Event{Type: termbox.EventKey, Key: termbox.KeyCtrlN}},
+		{tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone), termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter}},
+		{tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone), termbox.Event{Type: termbox.EventKey, Key: termbox.KeyBackspace2}},
+		{tcell.NewEventKey(tcell.KeyPgDn, 0, tcell.ModNone), termbox.Event{Type: termbox.EventKey, Key: termbox.KeyPgdn}},
+		{tcell.NewEventResize(80, 24), termbox.Event{Type: termbox.EventResize, Width: 80, Height: 24}},
+	}
+
+	for _, test := range tests {
+		ev, ok := tcellToTermboxEvent(test.event)
+		if !assert.True(t, ok, "%#v should be converted", test.event) {
+			return
+		}
+		if !assert.Equal(t, test.expected, ev, "events should match") {
+			return
+		}
+	}
+
+	_, ok := tcellToTermboxEvent(tcell.NewEventMouse(0, 0, tcell.Button1, tcell.ModNone))
+	if !assert.False(t, ok, "mouse events should be dropped") {
+		return
+	}
+}
+
+func TestTcell(t *testing.T) {
+	sim := tcell.NewSimulationScreen("UTF-8")
+	screen := &Tcell{screen: sim}
+	if !assert.NoError(t, screen.Init(nil), "Init should succeed") {
+		return
+	}
+	defer screen.Close()
+	sim.SetSize(20, 5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	evCh := screen.PollEvent(ctx, nil)
+
+	fg := termbox.RGBToAttribute(0xff, 0x88, 0x00)
+	screen.Print(PrintArgs{X: 1, Y: 2, Msg: "日本", Fg: fg, Bg: termbox.ColorBlue})
+	if !assert.NoError(t, screen.Flush(), "Flush should succeed") {
+		return
+	}
+
+	cells, width, _ := sim.GetContents()
+	cell := cells[2*width+1]
+	if !assert.Equal(t, []rune("日"), cell.Runes, "wide characters should be displayed") {
+		return
+	}
+	if !assert.Equal(t, tcellStyle(fg, termbox.ColorBlue), cell.Style, "24-bit colors should be displayed") {
+		return
+	}
+	if !assert.Equal(t, []rune("本"), cells[2*width+3].Runes, "wide characters should take 2 cells") {
+		return
+	}
+
+	sim.InjectKey(tcell.KeyCtrlA, 0, tcell.ModCtrl)
+	for {
+		select {
+		case <-ctx.Done():
+			t.Errorf("key was not received")
+			return
+		case ev := <-evCh:
+			// The size of the screen is reported first
+			if ev.Type != termbox.EventKey {
+				continue
+			}
+			assert.Equal(t, termbox.KeyCtrlA, ev.Key, "keys should be received")
+			return
+		}
+	}
+}
+
+func TestPopulateScreen(t *testing.T) {
+	p := New()
+	if !assert.NoError(t, p.populateScreen(CLIOptions{OptScreen: ScreenTypeTcell}), "populateScreen should succeed") {
+		return
+	}
+	if !assert.IsType(t, &Tcell{}, p.screen, "screen should be replaced") {
+		return
+	}
+
+	p = New()
+	p.config.Screen = ScreenTypeTcell
+	if !assert.NoError(t, p.populateScreen(CLIOptions{OptScreen: ScreenTypeTermbox}), "populateScreen should succeed") {
+		return
+	}
+	if !assert.IsType(t, &Termbox{}, p.screen, "command line should take precedence") {
+		return
+	}
+
+	p = newPeco()
+	if !assert.NoError(t, p.populateScreen(CLIOptions{OptScreen: ScreenTypeTcell}), "populateScreen should succeed") {
+		return
+	}
+	if !assert.IsType(t, &dummyScreen{}, p.screen, "screen given by the caller should be kept") {
+		return
+	}
+
+	if !assert.Error(t, p.populateScreen(CLIOptions{OptScreen: "curses"}), "unknown screen should be an error") {
+		return
+	}
+}
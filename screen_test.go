@@ -0,0 +1,28 @@
+package peco
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRGBToPalette(t *testing.T) {
+	tests := []struct {
+		attr     termbox.Attribute
+		use256   bool
+		expected termbox.Attribute
+	}{
+		{termbox.ColorRed | termbox.AttrBold, true, termbox.ColorRed | termbox.AttrBold},
+		{termbox.RGBToAttribute(0xff, 0x87, 0x00) | termbox.AttrBold, true, (208 + 1) | termbox.AttrBold},
+		{termbox.RGBToAttribute(0x20, 0x20, 0x20), true, 234 + 1},
+		{termbox.RGBToAttribute(0xff, 0x88, 0x00), false, termbox.ColorYellow},
+		{termbox.RGBToAttribute(0x20, 0x20, 0x20), false, termbox.ColorBlack},
+	}
+
+	for _, test := range tests {
+		if !assert.Equal(t, test.expected, rgbToPalette(test.attr, test.use256), "colors should match") {
+			return
+		}
+	}
+}
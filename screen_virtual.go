@@ -0,0 +1,352 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/keyseq"
+	"github.com/pkg/errors"
+)
+
+// NewVirtualScreen creates a new VirtualScreen of the given size
+func NewVirtualScreen(width, height int) *VirtualScreen {
+	s := &VirtualScreen{
+		events:  make(chan termbox.Event),
+		flushCh: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.resize(width, height)
+	return s
+}
+
+func newVirtualCells(n int) []virtualCell {
+	cells := make([]virtualCell, n)
+	for i := range cells {
+		cells[i].ch = ' '
+	}
+	return cells
+}
+
+// resize must be called while holding the lock
+func (s *VirtualScreen) resize(width, height int) {
+	s.width = width
+	s.height = height
+	s.back = newVirtualCells(width * height)
+	s.front = newVirtualCells(width * height)
+}
+
+// Init clears the screen
+func (s *VirtualScreen) Init(_ *Config) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.resize(s.width, s.height)
+	return nil
+}
+
+// Close makes the events that are being sent, and the ones that are
+// sent later, fail
+func (s *VirtualScreen) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	return nil
+}
+
+// Flush makes the cells that were drawn visible in the snapshots
+func (s *VirtualScreen) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	copy(s.front, s.back)
+	close(s.flushCh)
+	s.flushCh = make(chan struct{})
+	return nil
+}
+
+// PollEvent returns the channel that the events sent to the screen
+// are delivered through
+func (s *VirtualScreen) PollEvent(_ context.Context, _ *Config) chan termbox.Event {
+	return s.events
+}
+
+func (s *VirtualScreen) Print(args PrintArgs) int {
+	return screenPrint(s, args)
+}
+
+func (s *VirtualScreen) Resume()  {}
+func (s *VirtualScreen) Suspend() {}
+
+// SetCell draws a character. Cells outside of the screen are ignored
+func (s *VirtualScreen) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if x < 0 || y < 0 || x >= s.width || y >= s.height {
+		return
+	}
+	i := y*s.width + x
+	s.back[i] = virtualCell{ch: ch, fg: fg, bg: bg}
+
+	// Like the terminal, wide characters take the next cell as well
+	if runewidth.RuneWidth(ch) == 2 && x+1 < s.width {
+		s.back[i+1] = virtualCell{fg: fg, bg: bg}
+	}
+}
+
+func (s *VirtualScreen) SetCursor(x, y int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cursorX = x
+	s.cursorY = y
+}
+
+// Cursor returns the position of the cursor
+func (s *VirtualScreen) Cursor() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cursorX, s.cursorY
+}
+
+func (s *VirtualScreen) Size() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.width, s.height
+}
+
+// SendEvent sends an event to peco, and blocks until it is received
+// or the screen is closed
+func (s *VirtualScreen) SendEvent(ev termbox.Event) {
+	select {
+	case s.events <- ev:
+	case <-s.done:
+	}
+}
+
+// Resize changes the size of the screen, which is cleared, and tells
+// peco about it
+func (s *VirtualScreen) Resize(width, height int) {
+	s.mutex.Lock()
+	s.resize(width, height)
+	s.mutex.Unlock()
+
+	s.SendEvent(termbox.Event{Type: termbox.EventResize, Width: width, Height: height})
+}
+
+// SendKeys sends the given keys, which are named as in the config
+// file, such as "C-n", "M-v" or "Enter". Each of them may be a sequence
+// of keys separated by commas, such as "C-x,C-c"
+func (s *VirtualScreen) SendKeys(keys ...string) error {
+	for _, k := range keys {
+		list, err := keyseq.ToKeyList(k)
+		if err != nil {
+			return errors.Wrapf(err, "invalid key %s", k)
+		}
+
+		for _, key := range list {
+			ev := termbox.Event{Type: termbox.EventKey, Key: key.Key, Ch: key.Ch}
+			if key.Modifier == keyseq.ModAlt {
+				ev.Mod = termbox.ModAlt
+			}
+			s.SendEvent(ev)
+		}
+	}
+	return nil
+}
+
+// SendString types the given text
+func (s *VirtualScreen) SendString(text string) {
+	for _, r := range text {
+		if r == ' ' {
+			// This is what termbox does
+			s.SendEvent(termbox.Event{Type: termbox.EventKey, Key: termbox.KeySpace})
+			continue
+		}
+		s.SendEvent(termbox.Event{Type: termbox.EventKey, Ch: r})
+	}
+}
+
+// WaitFor waits until f returns true. f is called right away, and then
+// each time the screen is flushed
+func (s *VirtualScreen) WaitFor(ctx context.Context, f func(*VirtualScreen) bool) error {
+	for {
+		s.mutex.Lock()
+		flushCh := s.flushCh
+		s.mutex.Unlock()
+
+		if f(s) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "gave up waiting, the screen was:\n%s", s.StyledSnapshot())
+		case <-flushCh:
+		}
+	}
+}
+
+// WaitForText waits until the screen contains the given text
+func (s *VirtualScreen) WaitForText(ctx context.Context, text string) error {
+	return s.WaitFor(ctx, func(s *VirtualScreen) bool {
+		return strings.Contains(s.Snapshot(), text)
+	})
+}
+
+// Snapshot returns the text on the screen as of the last flush, a line
+// for each row. Trailing spaces are removed
+func (s *VirtualScreen) Snapshot() string {
+	return s.snapshot(false)
+}
+
+// StyledSnapshot is like Snapshot, but the characters that are not
+// drawn in the default style are annotated with their style, which is
+// named as in the config file: "[cyan,on_blue,bold]text[/]". A literal
+// "[" is written as "[["
+func (s *VirtualScreen) StyledSnapshot() string {
+	return s.snapshot(true)
+}
+
+func (s *VirtualScreen) snapshot(styled bool) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var buf bytes.Buffer
+	for y := 0; y < s.height; y++ {
+		row := s.front[y*s.width : (y+1)*s.width]
+
+		// Spaces in the default style at the end are not interesting
+		end := len(row)
+		for end > 0 && (row[end-1].ch == ' ' || row[end-1].ch == 0) && (!styled || isDefaultCell(row[end-1])) {
+			end--
+		}
+
+		var style string
+		for _, c := range row[:end] {
+			if styled {
+				if next := cellStyleName(c); next != style {
+					if style != "" {
+						buf.WriteString("[/]")
+					}
+					if next != "" {
+						buf.WriteString("[" + next + "]")
+					}
+					style = next
+				}
+			}
+
+			switch {
+			case c.ch == 0:
+			case styled && c.ch == '[':
+				buf.WriteString("[[")
+			default:
+				buf.WriteRune(c.ch)
+			}
+		}
+		if style != "" {
+			buf.WriteString("[/]")
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+func isDefaultCell(c virtualCell) bool {
+	return c.fg == termbox.ColorDefault && c.bg == termbox.ColorDefault
+}
+
+// cellStyleName returns the style of the cell as a comma separated
+// list of the names used in the config file, or an empty string for
+// the default style
+func cellStyleName(c virtualCell) string {
+	var names []string
+	if name := colorName(c.fg, ""); name != "" {
+		names = append(names, name)
+	}
+	if name := colorName(c.bg, "on_"); name != "" {
+		names = append(names, name)
+	}
+	for _, attr := range []struct {
+		name string
+		attr termbox.Attribute
+	}{{"bold", termbox.AttrBold}, {"underline", termbox.AttrUnderline}, {"reverse", termbox.AttrReverse}} {
+		if c.fg&attr.attr != 0 {
+			names = append(names, attr.name)
+		}
+	}
+	if c.bg&termbox.AttrBold != 0 {
+		names = append(names, "on_bold")
+	}
+	return strings.Join(names, ",")
+}
+
+var virtualColorNames = []string{"", "black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// colorName returns the name of the color in attr, or an empty string
+// for the default color
+func colorName(attr termbox.Attribute, prefix string) string {
+	if isRGBColor(attr) {
+		r, g, b := termbox.AttributeToRGB(attr)
+		return fmt.Sprintf("%s#%02x%02x%02x", prefix, r, g, b)
+	}
+
+	color := int(attr & 0x1ff)
+	switch {
+	case color == 0:
+		return ""
+	case color < len(virtualColorNames):
+		return prefix + virtualColorNames[color]
+	default:
+		// 256 colors are numbered from 0 in the config file
+		return prefix + strconv.Itoa(color-1)
+	}
+}
+
+// CompareGolden compares the styled snapshot of the screen with the
+// contents of the given file, and returns an error that describes the
+// difference if they do not match. If update is true, the file is
+// overwritten with the snapshot instead
+func (s *VirtualScreen) CompareGolden(filename string, update bool) error {
+	actual := s.StyledSnapshot()
+	if update {
+		return errors.Wrapf(ioutil.WriteFile(filename, []byte(actual), 0644), "failed to write %s", filename)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("%s does not exist. The screen was:\n%s", filename, actual)
+		}
+		return errors.Wrapf(err, "failed to read %s", filename)
+	}
+
+	expected := string(b)
+	if expected == actual {
+		return nil
+	}
+
+	expectedLines := strings.Split(expected, "\n")
+	actualLines := strings.Split(actual, "\n")
+	for i := 0; i < len(expectedLines) || i < len(actualLines); i++ {
+		var e, a string
+		if i < len(expectedLines) {
+			e = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			a = actualLines[i]
+		}
+		if e != a {
+			return errors.Errorf("screen does not match %s at line %d:\nexpected: %q\nactual:   %q\nthe screen was:\n%s", filename, i+1, e, a, actual)
+		}
+	}
+	return errors.Errorf("screen does not match %s", filename)
+}
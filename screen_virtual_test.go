@@ -0,0 +1,104 @@
+package peco
+
+import (
+	"context"
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestVirtualScreenSnapshot(t *testing.T) {
+	s := NewVirtualScreen(10, 2)
+	s.Print(PrintArgs{X: 0, Y: 0, Msg: "a[b", Fg: termbox.ColorCyan, Bg: termbox.ColorBlue | termbox.AttrBold})
+	s.Print(PrintArgs{X: 4, Y: 0, Msg: "c", Fg: termbox.Attribute(101) | termbox.AttrUnderline})
+	s.Print(PrintArgs{X: 0, Y: 1, Msg: "日本 x"})
+
+	if !assert.Equal(t, "\n\n", s.Snapshot(), "nothing should be visible before flushing") {
+		return
+	}
+
+	s.Flush()
+	if !assert.Equal(t, "a[b c\n日本 x\n", s.Snapshot(), "snapshot should match") {
+		return
+	}
+	if !assert.Equal(t, "[cyan,on_blue,on_bold]a[[b[/] [100,underline]c[/]\n日本 x\n", s.StyledSnapshot(), "styled snapshot should match") {
+		return
+	}
+}
+
+func TestVirtualScreen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	screen := NewVirtualScreen(40, 6)
+	defer screen.Close()
+
+	type result struct {
+		selected []string
+		err      error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		var cfg Config
+		cfg.Init()
+		cfg.Keymap = map[string]string{"C-t": "peco.SelectDown"}
+
+		selected, err := Select(ctx, []string{"foo", "bar", "baz", "qux"}, Options{
+			Config: &cfg,
+			Screen: screen,
+		})
+		resultCh <- result{selected, err}
+	}()
+
+	if !assert.NoError(t, screen.WaitForText(ctx, "qux"), "lines should be displayed") {
+		return
+	}
+	if !assert.NoError(t, screen.CompareGolden(filepath.Join("testdata", "virtual_screen_initial.golden"), *updateGolden), "screen should match") {
+		return
+	}
+
+	screen.SendString("ba")
+	err := screen.WaitFor(ctx, func(s *VirtualScreen) bool {
+		text := s.Snapshot()
+		return strings.Contains(text, "baz") && !strings.Contains(text, "foo")
+	})
+	if !assert.NoError(t, err, "lines should be filtered") {
+		return
+	}
+
+	if !assert.NoError(t, screen.SendKeys("C-t"), "keys should be sent") {
+		return
+	}
+	err = screen.WaitFor(ctx, func(s *VirtualScreen) bool {
+		return strings.Contains(s.StyledSnapshot(), "[cyan,on_magenta]ba[/][on_magenta,underline]z")
+	})
+	if !assert.NoError(t, err, "custom key binding should move the cursor") {
+		return
+	}
+	if !assert.NoError(t, screen.CompareGolden(filepath.Join("testdata", "virtual_screen_filtered.golden"), *updateGolden), "screen should match") {
+		return
+	}
+
+	if !assert.NoError(t, screen.SendKeys("Enter"), "keys should be sent") {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+		t.Errorf("peco did not exit")
+	case r := <-resultCh:
+		if !assert.NoError(t, r.err, "Select should succeed") {
+			return
+		}
+		if !assert.Equal(t, []string{"baz"}, r.selected, "selected lines should match") {
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package peco
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/google/btree"
+	"github.com/lestrrat-go/pdebug"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+	"github.com/pkg/errors"
+)
+
+// ErrCanceled is returned by Select and SelectReader when the user
+// cancels the selection
+var ErrCanceled = errors.New("canceled by user")
+
+// Select runs peco on the given lines, and returns the lines that the
+// user selected (or the line under the cursor, if none were
+// selected). The lines must not contain newlines
+func Select(ctx context.Context, lines []string, opts Options) ([]string, error) {
+	return SelectReader(ctx, strings.NewReader(strings.Join(lines, "\n")), opts)
+}
+
+// SelectReader is like Select, but reads the lines from r
+func SelectReader(ctx context.Context, r io.Reader, opts Options) (selected []string, err error) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("SelectReader").BindError(&err)
+		defer g.End()
+	}
+
+	var out bytes.Buffer
+	p := New()
+	p.Argv = append([]string{"peco"}, opts.Args...)
+	p.Stdin = r
+	p.Stdout = &out
+	p.skipReadConfig = true
+	p.customConfig = opts.Config
+	p.customFilters = opts.Filters
+	p.customActions = opts.Actions
+	if opts.Screen != nil {
+		p.screen = opts.Screen
+	}
+
+	err = p.Run(ctx)
+	switch {
+	case err == nil:
+		if p.filterMode {
+			// The results were printed, as there was no screen
+			return splitOutput(&out), nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrCanceled
+	case util.IsCollectResultsError(err):
+		sel := selectionOrCurrentLine(p)
+		sel.Ascend(func(it btree.Item) bool {
+			selected = append(selected, it.(line.Line).Output())
+			return true
+		})
+		return selected, nil
+	case util.IsIgnorableError(err):
+		return nil, ErrCanceled
+	default:
+		return nil, err
+	}
+}
+
+func splitOutput(r io.Reader) []string {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
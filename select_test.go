@@ -0,0 +1,128 @@
+package peco
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+type prefixQueryKey struct{}
+
+// prefixFilter matches the lines that start with the query
+type prefixFilter struct{}
+
+func (prefixFilter) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	query := ctx.Value(prefixQueryKey{}).(string)
+	for _, l := range lines {
+		if strings.HasPrefix(l.DisplayString(), query) {
+			out.Send(l)
+		}
+	}
+	return nil
+}
+
+func (prefixFilter) BufSize() int {
+	return 0
+}
+
+func (prefixFilter) NewContext(ctx context.Context, query string) context.Context {
+	return context.WithValue(ctx, prefixQueryKey{}, query)
+}
+
+func (prefixFilter) String() string {
+	return "Prefix"
+}
+
+func TestSelect(t *testing.T) {
+	newConfig := func(events map[string][]string) *Config {
+		var cfg Config
+		cfg.Init()
+		cfg.Event = events
+		return &cfg
+	}
+
+	t.Run("Custom action", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		selectSecond := ActionFunc(func(_ context.Context, state *Peco, _ termbox.Event) {
+			if l, err := state.CurrentLineBuffer().LineAt(1); err == nil {
+				state.Selection().Add(l)
+			}
+		})
+		selected, err := Select(ctx, []string{"foo", "bar", "baz"}, Options{
+			Config:  newConfig(map[string][]string{EventLoad: {"test.SelectSecond", "peco.Finish"}}),
+			Screen:  NewDummyScreen(),
+			Actions: map[string]Action{"test.SelectSecond": selectSecond},
+		})
+		if !assert.NoError(t, err, "Select should succeed") {
+			return
+		}
+		if !assert.Equal(t, []string{"bar"}, selected, "selected lines should match") {
+			return
+		}
+	})
+
+	t.Run("Custom filter", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		selected, err := Select(ctx, []string{"foo", "xbar", "bar"}, Options{
+			Args:    []string{"--initial-filter", "Prefix", "--query", "bar"},
+			Config:  newConfig(map[string][]string{EventOneMatch: {"peco.Finish"}}),
+			Screen:  NewDummyScreen(),
+			Filters: []filter.Filter{prefixFilter{}},
+		})
+		if !assert.NoError(t, err, "Select should succeed") {
+			return
+		}
+		if !assert.Equal(t, []string{"bar"}, selected, "selected lines should match") {
+			return
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := Select(ctx, []string{"foo"}, Options{
+			Config: newConfig(map[string][]string{EventLoad: {"peco.Cancel"}}),
+			Screen: NewDummyScreen(),
+		})
+		if !assert.Equal(t, ErrCanceled, err, "Select should be canceled") {
+			return
+		}
+	})
+
+	t.Run("Context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		_, err := Select(ctx, []string{"foo"}, Options{Screen: NewDummyScreen()})
+		if !assert.Equal(t, context.DeadlineExceeded, err, "Select should return the context's error") {
+			return
+		}
+	})
+
+	t.Run("Reader", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		selected, err := SelectReader(ctx, strings.NewReader("foo\nbar\nboo\n"), Options{
+			Args: []string{"--filter", "oo"},
+		})
+		if !assert.NoError(t, err, "SelectReader should succeed") {
+			return
+		}
+		if !assert.Equal(t, []string{"foo", "boo"}, selected, "selected lines should match") {
+			return
+		}
+	})
+}
@@ -32,6 +32,63 @@ func NewSource(name string, in io.Reader, isInfinite bool, idgen line.IDGenerato
 	return s
 }
 
+// SetFields sets the fields of each line that are displayed and
+// output. A nil scope selects the entire line. This must be called
+// before Setup
+func (s *Source) SetFields(display, output *line.FieldScope) {
+	s.displayFields = display
+	s.outputFields = output
+}
+
+// SetANSI makes the lines display the colors given by the ANSI escape
+// sequences in the input. If keepEscapes is true, the sequences are
+// kept in the output. This must be called before Setup
+func (s *Source) SetANSI(enabled, keepEscapes bool) {
+	s.ansi = enabled
+	s.keepANSI = keepEscapes
+}
+
+// SetJSON makes the lines JSON lines that are created by the given
+// format, or normal lines if it is nil. This must be called before Setup
+func (s *Source) SetJSON(jf *line.JSONFormat) {
+	s.jsonFormat = jf
+}
+
+// SetTable makes the lines rows of a table in the given format, or
+// normal lines if it is nil. This must be called before Setup
+func (s *Source) SetTable(tf *line.TableFormat) {
+	s.tableFormat = tf
+}
+
+// SetHeaderLines makes the first n lines the header of the input,
+// instead of lines to choose from. This must be called before Setup
+func (s *Source) SetHeaderLines(n int) {
+	s.headerLines = n
+}
+
+// Header returns the lines that were read as the header of the input
+func (s *Source) Header() []line.Line {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.header
+}
+
+func (s *Source) newLine(v string) line.Line {
+	if jf := s.jsonFormat; jf != nil {
+		return jf.NewLine(s.idgen.Next(), v, s.displayFields, s.outputFields)
+	}
+	if tf := s.tableFormat; tf != nil {
+		return tf.NewLine(s.idgen.Next(), v, s.enableSep, s.displayFields, s.outputFields)
+	}
+	if s.ansi {
+		return line.NewANSI(s.idgen.Next(), v, s.enableSep, s.displayFields, s.outputFields, s.keepANSI)
+	}
+	if s.displayFields == nil && s.outputFields == nil {
+		return line.NewRaw(s.idgen.Next(), v, s.enableSep)
+	}
+	return line.NewFielded(s.idgen.Next(), v, s.enableSep, s.displayFields, s.outputFields)
+}
+
 func (s *Source) Name() string {
 	return s.name
 }
@@ -145,7 +202,16 @@ func (s *Source) Setup(ctx context.Context, state *Peco) {
 				}
 
 				readCount++
-				s.Append(line.NewRaw(s.idgen.Next(), l, s.enableSep))
+				if readCount <= s.headerLines {
+					// The header is displayed, but it is not filtered,
+					// and it is not one of the lines to choose from
+					s.mutex.Lock()
+					s.header = append(s.header, s.newLine(l))
+					s.mutex.Unlock()
+					notify.Do(notifycb)
+					continue
+				}
+				s.Append(s.newLine(l))
 				notify.Do(notifycb)
 			}
 		}
@@ -206,6 +272,20 @@ func (s *Source) Start(ctx context.Context, out pipeline.ChanOutput) {
 			return
 		}
 
+		if upto == prev {
+			// Wait for more lines, instead of spinning while the input
+			// is being read. Followed files and streams may not have
+			// anything new for a long time
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.setupDone:
+				setupDone = true
+			case <-time.After(10 * time.Millisecond):
+			}
+			continue
+		}
+
 		for i := prev; i < upto; i++ {
 			select {
 			case <-ctx.Done():
@@ -86,3 +86,40 @@ func TestSource(t *testing.T) {
 		}
 	}
 }
+
+func TestSourceHeaderLines(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	s := NewSource("-", strings.NewReader("ID NAME\n-- ----\n1 foo\n2 bar"), false, ig, 0, false)
+	s.SetHeaderLines(2)
+	p := New()
+	p.hub = nullHub{}
+	go s.Setup(ctx, p)
+
+	select {
+	case <-s.SetupDone():
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "timed out waiting for source")
+		return
+	}
+
+	header := s.Header()
+	if !assert.Len(t, header, 2, "there should be 2 header lines") {
+		return
+	}
+	if !assert.Equal(t, "-- ----", header[1].DisplayString(), "header lines should be in order") {
+		return
+	}
+	if !assert.Equal(t, 2, s.Size(), "header lines should not be in the buffer") {
+		return
+	}
+	l, err := s.LineAt(0)
+	if !assert.NoError(t, err, "s.LineAt(0) should succeed") {
+		return
+	}
+	assert.Equal(t, "1 foo", l.DisplayString(), "first line should be after the header")
+}
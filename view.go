@@ -24,6 +24,14 @@ func (jlr JumpToLineRequest) Line() int {
 	return int(jlr)
 }
 
+func (lnr LineNumberRequest) Type() PagingRequestType {
+	return ToLineNumber
+}
+
+func (lnr LineNumberRequest) Line() int {
+	return int(lnr)
+}
+
 func NewView(state *Peco) *View {
 	var layout Layout
 	switch state.LayoutType() {
@@ -62,6 +70,8 @@ func (v *View) Loop(ctx context.Context, cancel func()) error {
 				}
 			case *DrawOptions:
 				v.drawScreen(r, tmp.(*DrawOptions))
+			case *remoteStateRequest:
+				v.remoteState(r, tmp.(*remoteStateRequest))
 			default:
 				v.drawScreen(r, nil)
 			}
@@ -86,6 +96,12 @@ func (v *View) drawScreen(p hub.Payload, options *DrawOptions) {
 	v.layout.DrawScreen(v.state, options)
 }
 
+func (v *View) remoteState(p hub.Payload, r *remoteStateRequest) {
+	defer p.Done()
+
+	r.state = v.state.remoteState()
+}
+
 func (v *View) drawPrompt(p hub.Payload) {
 	defer p.Done()
 